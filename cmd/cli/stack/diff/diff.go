@@ -0,0 +1,31 @@
+package diff
+
+import (
+	"github.com/groundctl/groundctl/internal/cli/stack"
+	"github.com/spf13/cobra"
+)
+
+var c stack.DiffCmd
+
+var DiffCmd = &cobra.Command{
+	Use:   "diff old new",
+	Short: "Compare two versions of a stack template file.",
+	Long: `Compare two versions of a stack template file.
+
+Changes are reported in stack terms, like an input that was added, a step
+param that changed or an output that was removed, rather than as changed
+lines. Changes that can break deployments or users of the stack, like
+removed inputs or new required inputs without a default, are marked as
+breaking.
+
+Stacks are groundctl's environment templates.`,
+	Aliases: []string{"d"},
+	Args:    cobra.ExactArgs(2),
+	Example: "groundctl stack diff old.yml new.yml\ngroundctl stack diff --json --fail-on-breaking old.yml new.yml",
+	RunE:    c.Run,
+}
+
+func init() {
+	DiffCmd.Flags().BoolVar(&c.JSON, "json", false, "print the changes as JSON")
+	DiffCmd.Flags().BoolVar(&c.FailOnBreaking, "fail-on-breaking", false, "fail if any of the changes is breaking")
+}
@@ -0,0 +1,40 @@
+package docs
+
+import (
+	"github.com/groundctl/groundctl/internal/cli/stack"
+	"github.com/spf13/cobra"
+)
+
+var c stack.DocsCmd
+
+var DocsCmd = &cobra.Command{
+	Use:   "docs filename",
+	Short: "Generate reference docs for a stack.",
+	Long: `Generate Markdown or HTML reference docs for a stack, with its metadata,
+provider, inputs, secrets, layers and steps, and outputs.
+
+A custom Go template can be given with --template. It's rendered with the
+stack's docs, like {{ .Title }}, {{ range .Inputs }} and {{ range .Layers }},
+and can use the functions code, codeCell, value, cell, join and
+allowedValues.
+
+With --update, the Markdown docs replace the section of an existing file,
+like a README, between these markers:
+
+  <!-- BEGIN GROUNDCTL DOCS -->
+  <!-- END GROUNDCTL DOCS -->
+
+Stacks are groundctl's environment templates.`,
+	Args:    cobra.ExactArgs(1),
+	Example: "groundctl stack docs example.stack > STACK.md\ngroundctl stack docs --update README.md example.stack",
+	RunE:    c.Run,
+}
+
+func init() {
+	DocsCmd.Flags().BoolVar(&c.HTML, "html", false, "render HTML instead of Markdown")
+	DocsCmd.Flags().StringVar(&c.Template, "template", "", "a Go template file to render the docs with")
+	DocsCmd.Flags().StringVarP(&c.Output, "output", "o", "", "write the docs to a file instead of stdout")
+	DocsCmd.Flags().StringVar(&c.Update, "update", "", "replace the marked docs section of a file, like a README")
+	DocsCmd.MarkFlagsMutuallyExclusive("output", "update")
+	DocsCmd.MarkFlagsMutuallyExclusive("html", "update")
+}
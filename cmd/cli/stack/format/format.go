@@ -0,0 +1,30 @@
+package format
+
+import (
+	"github.com/groundctl/groundctl/internal/cli/stack"
+	"github.com/spf13/cobra"
+)
+
+var c stack.FmtCmd
+
+var FmtCmd = &cobra.Command{
+	Use:   "fmt path...",
+	Short: "Format stack template files.",
+	Long: `Format stack template files in the canonical style.
+
+The keys of every stack element are put in a fixed order, like name, action,
+params, register and tags for steps. Strings are only quoted where needed,
+collections are indented by two spaces and comments are kept. Directories are
+searched for .yml, .yaml and .stack files.
+
+Stacks are groundctl's environment templates.`,
+	Aliases: []string{"format"},
+	Args:    cobra.MinimumNArgs(1),
+	Example: "groundctl stack fmt example.stack\ngroundctl stack fmt --check stacks/",
+	RunE:    c.Run,
+}
+
+func init() {
+	FmtCmd.Flags().BoolVar(&c.Check, "check", false, "don't write the files, and fail if any of them need formatting")
+	FmtCmd.Flags().BoolVar(&c.Diff, "diff", false, "don't write the files, and print the changes as a diff")
+}
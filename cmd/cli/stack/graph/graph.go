@@ -0,0 +1,35 @@
+package graph
+
+import (
+	"github.com/groundctl/groundctl/internal/cli/stack"
+	"github.com/spf13/cobra"
+)
+
+var c stack.GraphCmd
+
+var GraphCmd = &cobra.Command{
+	Use:   "graph filename",
+	Short: "Render the data flow graph of a stack.",
+	Long: `Render the data flow graph of a stack as Graphviz DOT or a Mermaid flowchart.
+
+The graph has nodes for inputs, secrets, locals, steps (grouped by layer) and
+outputs, with edges from the values templates reference to the elements
+referencing them. Dashed edges are depends_on entries.
+
+Use --upstream or --downstream to highlight everything a node's values come
+from or flow into. Nodes are given as a step name, or as input.NAME,
+secret.NAME, local.NAME or output.NAME.
+
+Stacks are groundctl's environment templates.`,
+	Aliases: []string{"g"},
+	Args:    cobra.ExactArgs(1),
+	Example: "groundctl stack graph example.stack | dot -Tsvg > graph.svg\ngroundctl stack graph --mermaid --downstream input.region example.stack",
+	RunE:    c.Run,
+}
+
+func init() {
+	GraphCmd.Flags().BoolVar(&c.Mermaid, "mermaid", false, "render a Mermaid flowchart instead of DOT")
+	GraphCmd.Flags().StringVar(&c.Upstream, "upstream", "", "highlight the node and everything upstream of it")
+	GraphCmd.Flags().StringVar(&c.Downstream, "downstream", "", "highlight the node and everything downstream of it")
+	GraphCmd.MarkFlagsMutuallyExclusive("upstream", "downstream")
+}
@@ -0,0 +1,39 @@
+package lint
+
+import (
+	"github.com/groundctl/groundctl/internal/cli/stack"
+	"github.com/spf13/cobra"
+)
+
+var c stack.LintCmd
+
+var LintCmd = &cobra.Command{
+	Use:   "lint filename",
+	Short: "Check a stack template file for style and hygiene problems.",
+	Long: `Check a stack template file for style and hygiene problems, like unused
+inputs, missing descriptions or SSH open to the world.
+
+Rules are configured in a .groundctl-lint.yaml file in the working directory,
+or the file given with --config:
+
+  rules:
+    missing-label: off
+    unused-input: error
+    open-ingress:
+      ports: [22, 3389]
+
+Problems can be ignored with a "# groundctl:ignore RULE" comment on the line
+of the element they are found in, or right above it. Use --list-rules to see
+every rule.
+
+Stacks are groundctl's environment templates.`,
+	Aliases: []string{"l"},
+	Args:    cobra.MaximumNArgs(1),
+	Example: "groundctl stack lint example.stack",
+	RunE:    c.Run,
+}
+
+func init() {
+	LintCmd.Flags().StringVar(&c.Config, "config", "", "lint config file (default .groundctl-lint.yaml)")
+	LintCmd.Flags().BoolVar(&c.ListRules, "list-rules", false, "list every lint rule and its default severity")
+}
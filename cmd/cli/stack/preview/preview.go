@@ -12,9 +12,27 @@ var PreviewCmd = &cobra.Command{
 	Short: "Preview a stack.",
 	Long: `Preview a stack.
 
+Input values can be given with --input-file, --set or GROUNDCTL_INPUT_<NAME>
+environment variables. --set takes precedence over the environment, which
+takes precedence over input files. Secret values can be given with
+GROUNDCTL_SECRET_<NAME> environment variables, and are never shown. In these
+variable names, the name is upper cased and characters other than letters,
+digits and underscores are replaced with underscores.
+
+When run in a terminal, missing required inputs are prompted for unless
+--no-input is given. Missing secrets are left masked, unless --prompt-secrets
+is given to prompt for them.
+
 Stacks are groundctl's environment templates.`,
 	Aliases: []string{"p"},
 	Args:    cobra.ExactArgs(1),
-	Example: "groundctl preview example.stack",
+	Example: "groundctl preview example.stack --set environment=dev",
 	RunE:    c.Run,
 }
+
+func init() {
+	PreviewCmd.Flags().StringArrayVar(&c.Inputs.Files, "input-file", nil, "a YAML or JSON file of input values (repeatable, later files take precedence)")
+	PreviewCmd.Flags().StringArrayVar(&c.Inputs.Set, "set", nil, "set an input value as key=value (repeatable)")
+	PreviewCmd.Flags().BoolVar(&c.Inputs.NoInput, "no-input", false, "never prompt for missing input or secret values")
+	PreviewCmd.Flags().BoolVar(&c.PromptSecrets, "prompt-secrets", false, "prompt for missing secret values when run in a terminal")
+}
@@ -2,6 +2,11 @@ package stack
 
 import (
 	"github.com/groundctl/groundctl/cmd/cli/stack/check"
+	"github.com/groundctl/groundctl/cmd/cli/stack/diff"
+	"github.com/groundctl/groundctl/cmd/cli/stack/docs"
+	"github.com/groundctl/groundctl/cmd/cli/stack/format"
+	"github.com/groundctl/groundctl/cmd/cli/stack/graph"
+	"github.com/groundctl/groundctl/cmd/cli/stack/lint"
 	"github.com/groundctl/groundctl/cmd/cli/stack/preview"
 	"github.com/spf13/cobra"
 )
@@ -18,6 +23,11 @@ Stacks are groundctl's environment templates.`,
 func init() {
 	StackCmd.AddCommand(
 		check.CheckCmd,
+		diff.DiffCmd,
+		docs.DocsCmd,
+		format.FmtCmd,
+		graph.GraphCmd,
+		lint.LintCmd,
 		preview.PreviewCmd,
 	)
 }
@@ -1,9 +1,7 @@
 package stack
 
 import (
-	"errors"
 	"fmt"
-	"os"
 
 	"github.com/groundctl/groundctl/pkg/stack"
 	"github.com/sirupsen/logrus"
@@ -16,25 +14,33 @@ func (c *CheckCmd) Run(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
 	}
-	// Read the template file
-	templateBytes, err := os.ReadFile(args[0])
+	// Read and parse the template file
+	parsedStack, err := loadStack(args[0])
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("file does not exist")
-		}
-		return fmt.Errorf("failed to read file: %v", err)
-	}
-	// Parse as a template
-	logrus.Debug("Parsing stack...")
-	parsedStack, err := stack.Parse(templateBytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse stack template: %v", err)
+		return err
 	}
 	// Validate the stack
 	logrus.Debug("Validating stack...")
-	if err = parsedStack.Validate(); err != nil {
-		return fmt.Errorf("failed to validate stack: %v", err)
+	diags := parsedStack.Diagnose()
+	logDiagnostics(diags)
+	if diags.HasErrors() {
+		return fmt.Errorf("stack file %q has %d error(s)", args[0], diags.Count(stack.SeverityError))
 	}
 	logrus.Infof("Stack file %q is valid!", args[0])
 	return nil
 }
+
+// Logs every diagnostic at the level matching its severity. The position and
+// code are part of the text, so they aren't added as fields.
+func logDiagnostics(diags stack.Diagnostics) {
+	for _, d := range diags {
+		switch d.Severity {
+		case stack.SeverityError:
+			logrus.Error(d.String())
+		case stack.SeverityWarning:
+			logrus.Warn(d.String())
+		default:
+			logrus.Info(d.String())
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type DiffCmd struct {
+	JSON bool
+	// Fail when any of the changes is breaking
+	FailOnBreaking bool
+}
+
+// The JSON output of the diff command
+type diffOutput struct {
+	Changes  stack.Changes `json:"changes"`
+	Breaking bool          `json:"breaking"`
+}
+
+func (c *DiffCmd) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("accepts 2 arg(s), received %d", len(args))
+	}
+	oldStack, err := loadStack(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	newStack, err := loadStack(args[1])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[1], err)
+	}
+	logrus.Debug("Comparing stacks...")
+	changes := stack.Diff(oldStack, newStack)
+
+	out := cmd.OutOrStdout()
+	if c.JSON {
+		if changes == nil {
+			changes = stack.Changes{}
+		}
+		data, err := json.MarshalIndent(diffOutput{Changes: changes, Breaking: changes.HasBreaking()}, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	} else if len(changes) == 0 {
+		logrus.Info("No changes")
+	} else {
+		for _, change := range changes {
+			fmt.Fprintln(out, change)
+		}
+	}
+	if c.FailOnBreaking && changes.HasBreaking() {
+		breaking := 0
+		for _, change := range changes {
+			if change.Breaking {
+				breaking++
+			}
+		}
+		return fmt.Errorf("%d breaking change(s)", breaking)
+	}
+	return nil
+}
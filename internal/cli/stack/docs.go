@@ -0,0 +1,92 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type DocsCmd struct {
+	HTML bool
+	// File with a Go template to render instead of the default one
+	Template string
+	// File to write the docs to instead of stdout
+	Output string
+	// File, like a README, whose marked docs section is replaced
+	Update string
+}
+
+func (c *DocsCmd) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
+	}
+	if c.Update != "" && c.Output != "" {
+		return fmt.Errorf("--update and --output can't be used together")
+	}
+	if c.Update != "" && c.HTML {
+		return fmt.Errorf("--update only works with Markdown docs, not with --html")
+	}
+	// Read and parse the template file
+	parsedStack, err := loadStack(args[0])
+	if err != nil {
+		return err
+	}
+	tmpl := ""
+	if c.Template != "" {
+		data, err := os.ReadFile(c.Template)
+		if err != nil {
+			return fmt.Errorf("failed to read docs template: %v", err)
+		}
+		tmpl = string(data)
+	}
+	logrus.Debug("Rendering docs...")
+	render := parsedStack.RenderDocs
+	if c.HTML {
+		render = parsedStack.RenderHTMLDocs
+	}
+	docs, err := render(tmpl)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c.Update != "":
+		return updateDocs(c.Update, docs)
+	case c.Output != "":
+		if err := os.WriteFile(c.Output, []byte(docs), 0o644); err != nil {
+			return fmt.Errorf("failed to write docs: %v", err)
+		}
+		logrus.Infof("Wrote docs to %q", c.Output)
+	default:
+		fmt.Fprint(cmd.OutOrStdout(), docs)
+	}
+	return nil
+}
+
+// Replaces the marked docs section of a file
+func updateDocs(filename, docs string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %v", filename, err)
+	}
+	updated, err := stack.UpdateDocsSection(string(data), docs)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	if updated == string(data) {
+		logrus.Infof("Docs in %q are up to date", filename)
+		return nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, []byte(updated), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %q: %v", filename, err)
+	}
+	logrus.Infof("Updated docs in %q", filename)
+	return nil
+}
@@ -0,0 +1,130 @@
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// Extensions of the stack template files formatted in directories
+var stackExtensions = []string{".yml", ".yaml", ".stack"}
+
+type FmtCmd struct {
+	// Only report the files that aren't formatted, without changing them
+	Check bool
+	// Print the changes as a diff instead of writing them
+	Diff bool
+}
+
+func (c *FmtCmd) Run(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("requires at least 1 arg(s), only received 0")
+	}
+	files, err := stackFiles(args)
+	if err != nil {
+		return err
+	}
+	unformatted := 0
+	for _, filename := range files {
+		changed, err := c.format(cmd, filename)
+		if err != nil {
+			return err
+		}
+		if changed {
+			unformatted++
+		}
+	}
+	if c.Check && unformatted > 0 {
+		return fmt.Errorf("%d of %d stack file(s) need formatting", unformatted, len(files))
+	}
+	return nil
+}
+
+// Formats a single file, reporting whether it changed
+func (c *FmtCmd) format(cmd *cobra.Command, filename string) (bool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, fmt.Errorf("failed to read stack template: %v", err)
+	}
+	formatted, err := stack.FormatFile(filename, data)
+	if err != nil {
+		return false, fmt.Errorf("failed to format stack template: %v", err)
+	}
+	if bytes.Equal(data, formatted) {
+		logrus.Debugf("Stack file %q is formatted", filename)
+		return false, nil
+	}
+	if c.Diff {
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(data)),
+			B:        difflib.SplitLines(string(formatted)),
+			FromFile: filename,
+			ToFile:   filename + " (formatted)",
+			Context:  3,
+		})
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprint(cmd.OutOrStdout(), diff)
+	}
+	if c.Check {
+		logrus.Warnf("Stack file %q needs formatting", filename)
+	}
+	if c.Check || c.Diff {
+		return true, nil
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(filename, formatted, info.Mode().Perm()); err != nil {
+		return false, fmt.Errorf("failed to write stack template: %v", err)
+	}
+	logrus.Infof("Formatted %q", filename)
+	return true, nil
+}
+
+// Returns the given files, and the stack template files below the given
+// directories. Hidden files and directories are skipped.
+func stackFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", path, err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			hidden := p != path && strings.HasPrefix(d.Name(), ".")
+			if d.IsDir() {
+				if hidden {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !hidden && slices.Contains(stackExtensions, filepath.Ext(p)) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %q: %v", path, err)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,53 @@
+package stack
+
+import (
+	"fmt"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type GraphCmd struct {
+	// Render Mermaid instead of DOT
+	Mermaid bool
+	// Node whose upstream or downstream closure is highlighted
+	Upstream   string
+	Downstream string
+}
+
+func (c *GraphCmd) Run(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
+	}
+	// Read and parse the template file
+	parsedStack, err := loadStack(args[0])
+	if err != nil {
+		return err
+	}
+	logrus.Debug("Building data flow graph...")
+	flow, err := parsedStack.DataFlow()
+	if err != nil {
+		return fmt.Errorf("invalid stack: %v", err)
+	}
+
+	opts := stack.RenderOptions{Title: parsedStack.Name}
+	for id, dir := range map[string]stack.FlowDirection{c.Upstream: stack.FlowUpstream, c.Downstream: stack.FlowDownstream} {
+		if id == "" {
+			continue
+		}
+		n := flow.Find(id)
+		if n == nil {
+			return fmt.Errorf("unknown node %q, expected a step name or input.NAME, secret.NAME, local.NAME or output.NAME", id)
+		}
+		opts.Focus = n
+		opts.Highlight = flow.Closure(n, dir)
+	}
+
+	if c.Mermaid {
+		fmt.Fprint(cmd.OutOrStdout(), flow.Mermaid(opts))
+	} else {
+		fmt.Fprint(cmd.OutOrStdout(), flow.DOT(opts))
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package stack
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Prefix of environment variables that supply input values, e.g. GROUNDCTL_INPUT_REGION
+	inputEnvPrefix = "GROUNDCTL_INPUT_"
+	// Prefix of environment variables that supply secret values, e.g. GROUNDCTL_SECRET_API_KEY
+	secretEnvPrefix = "GROUNDCTL_SECRET_"
+)
+
+// InputFlags are the command line flags used to supply stack input values
+type InputFlags struct {
+	// YAML or JSON files of input values, later files take precedence
+	Files []string
+	// key=value pairs, these take precedence over everything else
+	Set []string
+	// Never prompt for missing values, even when running in a terminal
+	NoInput bool
+	// Prompts for missing values in place of the terminal, used by tests
+	prompter *prompter
+}
+
+// Given reports whether any input values were supplied, either by flags or
+// through the environment
+func (f *InputFlags) Given(s *stack.Stack) bool {
+	if len(f.Files) > 0 || len(f.Set) > 0 {
+		return true
+	}
+	for name := range s.Inputs {
+		if _, ok := os.LookupEnv(inputEnvName(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Wanted reports whether input values should be loaded: when some were given,
+// or when the stack has required inputs that can be prompted for
+func (f *InputFlags) Wanted(s *stack.Stack) bool {
+	if f.Given(s) {
+		return true
+	}
+	if !f.canPrompt() {
+		return false
+	}
+	for _, input := range s.Inputs {
+		if input.Required {
+			return true
+		}
+	}
+	return false
+}
+
+// Load merges the input values from input files, environment variables and
+// --set flags (in increasing order of precedence) and prepares them for the stack.
+// Missing required inputs are prompted for when running in a terminal.
+func (f *InputFlags) Load(s *stack.Stack) (map[string]any, error) {
+	values := make(map[string]any)
+	for _, file := range f.Files {
+		logrus.Debugf("Reading input values from %q", file)
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read input file: %v", err)
+		}
+		var fileValues map[string]any
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, fmt.Errorf("failed to parse input file %q: %v", file, err)
+		}
+		for name, val := range fileValues {
+			values[name] = val
+		}
+	}
+	for name := range s.Inputs {
+		raw, ok := os.LookupEnv(inputEnvName(name))
+		if !ok {
+			continue
+		}
+		logrus.Debugf("Reading input %q from the environment", name)
+		val, err := parseInputValue(s, name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in %s: %v", inputEnvName(name), err)
+		}
+		values[name] = val
+	}
+	for _, set := range f.Set {
+		name, raw, ok := strings.Cut(set, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid --set %q, expected key=value", set)
+		}
+		val, err := parseInputValue(s, name, raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for --set %s: %v", name, err)
+		}
+		values[name] = val
+	}
+	if f.canPrompt() {
+		p := f.newPrompter()
+		for _, name := range sortedKeys(s.Inputs) {
+			if _, ok := values[name]; ok || !s.Inputs[name].Required {
+				continue
+			}
+			val, err := p.promptInput(s, name)
+			if err != nil {
+				return nil, err
+			}
+			values[name] = val
+		}
+	}
+	return s.PrepareInputs(values)
+}
+
+// LoadSecrets reads secret values from GROUNDCTL_SECRET_<NAME> environment
+// variables. Missing secrets are prompted for when prompt is set and running
+// in a terminal, and left out otherwise.
+func (f *InputFlags) LoadSecrets(s *stack.Stack, prompt bool) (map[string]string, error) {
+	secrets := make(map[string]string, len(s.Secrets))
+	var p *prompter
+	for _, name := range sortedKeys(s.Secrets) {
+		if val, ok := os.LookupEnv(secretEnvName(name)); ok {
+			logrus.Debugf("Reading secret %q from the environment", name)
+			secrets[name] = val
+			continue
+		}
+		if !prompt || !f.canPrompt() {
+			continue
+		}
+		if p == nil {
+			p = f.newPrompter()
+		}
+		val, err := p.promptSecret(name, s.Secrets[name])
+		if err != nil {
+			return nil, err
+		}
+		secrets[name] = val
+	}
+	return secrets, nil
+}
+
+func (f *InputFlags) canPrompt() bool {
+	return !f.NoInput && (f.prompter != nil || isInteractive())
+}
+
+func (f *InputFlags) newPrompter() *prompter {
+	if f.prompter != nil {
+		return f.prompter
+	}
+	return newTerminalPrompter()
+}
+
+func inputEnvName(name string) string {
+	return envName(inputEnvPrefix, name)
+}
+
+func secretEnvName(name string) string {
+	return envName(secretEnvPrefix, name)
+}
+
+// Returns the name of the environment variable of an input or secret. Names
+// are upper cased, and characters shells don't allow in variable names, like
+// "-" and ".", are replaced with "_".
+func envName(prefix, name string) string {
+	return prefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Parses a raw value given on the command line. Values for string inputs are
+// kept as-is, everything else is parsed as YAML so numbers, bools, lists and
+// maps can be given, e.g. --set zones=[a,b].
+func parseInputValue(s *stack.Stack, name, raw string) (any, error) {
+	if input, ok := s.Inputs[name]; ok && input.Type == string(stack.TypeString) {
+		return raw, nil
+	}
+	var val any
+	if err := yaml.Unmarshal([]byte(raw), &val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
@@ -0,0 +1,194 @@
+package stack
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inputsStack = `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    required: true
+  size:
+    type: integer
+    default: 1
+  zones:
+    type: list(string)
+    default: [a]
+  log-level:
+    type: string
+    default: info
+secrets:
+  api-key:
+    type: string
+  db_password:
+    type: string
+layers: []
+`
+
+func TestEnvName(t *testing.T) {
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "region", expected: "GROUNDCTL_INPUT_REGION"},
+		{name: "log-level", expected: "GROUNDCTL_INPUT_LOG_LEVEL"},
+		{name: "db.port", expected: "GROUNDCTL_INPUT_DB_PORT"},
+		{name: "Zone_2", expected: "GROUNDCTL_INPUT_ZONE_2"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, inputEnvName(tt.name))
+		})
+	}
+	assert.Equal(t, "GROUNDCTL_SECRET_API_KEY", secretEnvName("api-key"))
+}
+
+func TestParseInputValue(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(inputsStack))
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		input    string
+		raw      string
+		expected any
+		err      bool
+	}{
+		{name: "string kept as-is", input: "region", raw: "true", expected: "true"},
+		{name: "string with spaces", input: "region", raw: " us-east-1 ", expected: " us-east-1 "},
+		{name: "integer", input: "size", raw: "3", expected: 3},
+		{name: "list", input: "zones", raw: "[a, b]", expected: []any{"a", "b"}},
+		{name: "unknown input", input: "missing", raw: "{a: 1}", expected: map[string]any{"a": 1}},
+		{name: "invalid yaml", input: "zones", raw: "[a", err: true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			val, err := parseInputValue(s, tt.input, tt.raw)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, val)
+		})
+	}
+}
+
+func TestInputFlagsLoad(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(inputsStack))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "inputs.yml")
+	require.NoError(t, os.WriteFile(file, []byte("region: file\nsize: 2\nzones: [f]\n"), 0o644))
+	override := filepath.Join(dir, "override.json")
+	require.NoError(t, os.WriteFile(override, []byte(`{"size": 3}`), 0o644))
+
+	cases := []struct {
+		name     string
+		files    []string
+		env      map[string]string
+		set      []string
+		prompt   string
+		expected map[string]any
+		err      string
+	}{
+		{
+			name:     "defaults and prompt",
+			prompt:   "prompted\n",
+			expected: map[string]any{"region": "prompted", "size": 1, "zones": []any{"a"}, "log-level": "info"},
+		},
+		{
+			name:     "later files take precedence",
+			files:    []string{file, override},
+			expected: map[string]any{"region": "file", "size": 3, "zones": []any{"f"}, "log-level": "info"},
+		},
+		{
+			name:     "environment over files",
+			files:    []string{file},
+			env:      map[string]string{"GROUNDCTL_INPUT_SIZE": "4", "GROUNDCTL_INPUT_LOG_LEVEL": "debug"},
+			expected: map[string]any{"region": "file", "size": 4, "zones": []any{"f"}, "log-level": "debug"},
+		},
+		{
+			name:     "set over environment",
+			files:    []string{file},
+			env:      map[string]string{"GROUNDCTL_INPUT_SIZE": "4", "GROUNDCTL_INPUT_REGION": "env"},
+			set:      []string{"size=5", "zones=[x, y]"},
+			expected: map[string]any{"region": "env", "size": 5, "zones": []any{"x", "y"}, "log-level": "info"},
+		},
+		{
+			name:     "given values aren't prompted for",
+			set:      []string{"region=set"},
+			prompt:   "prompted\n",
+			expected: map[string]any{"region": "set", "size": 1, "zones": []any{"a"}, "log-level": "info"},
+		},
+		{
+			name: "invalid set",
+			set:  []string{"region"},
+			err:  `invalid --set "region", expected key=value`,
+		},
+		{
+			name: "invalid environment value",
+			env:  map[string]string{"GROUNDCTL_INPUT_ZONES": "[a"},
+			err:  "invalid value in GROUNDCTL_INPUT_ZONES",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, val := range tt.env {
+				t.Setenv(name, val)
+			}
+			f := &InputFlags{Files: tt.files, Set: tt.set, prompter: testPrompter(tt.prompt, io.Discard)}
+			values, err := f.Load(s)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, values)
+		})
+	}
+
+	t.Run("no input", func(t *testing.T) {
+		f := &InputFlags{NoInput: true, prompter: testPrompter("prompted\n", io.Discard)}
+		_, err := f.Load(s)
+		assert.ErrorIs(t, err, stack.ErrMissingInput)
+	})
+}
+
+func TestInputFlagsLoadSecrets(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(inputsStack))
+	require.NoError(t, err)
+	t.Setenv("GROUNDCTL_SECRET_API_KEY", "from-env")
+
+	t.Run("missing secrets are left out", func(t *testing.T) {
+		f := &InputFlags{prompter: testPrompter("", io.Discard, "prompted")}
+		secrets, err := f.LoadSecrets(s, false)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"api-key": "from-env"}, secrets)
+	})
+
+	t.Run("missing secrets are prompted for", func(t *testing.T) {
+		f := &InputFlags{prompter: testPrompter("", io.Discard, "prompted")}
+		secrets, err := f.LoadSecrets(s, true)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"api-key": "from-env", "db_password": "prompted"}, secrets)
+
+		f.NoInput = true
+		secrets, err = f.LoadSecrets(s, true)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"api-key": "from-env"}, secrets)
+	})
+}
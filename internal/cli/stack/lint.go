@@ -0,0 +1,72 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type LintCmd struct {
+	// Lint config file, defaulting to .groundctl-lint.yaml if it exists
+	Config    string
+	ListRules bool
+}
+
+func (c *LintCmd) Run(cmd *cobra.Command, args []string) error {
+	if c.ListRules {
+		for _, rule := range stack.LintRules() {
+			fmt.Fprintf(cmd.OutOrStdout(), "%-22s %-8s %s\n", rule.ID, rule.Severity, rule.Description)
+		}
+		return nil
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
+	}
+	config, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	// Read and parse the template file
+	parsedStack, err := loadStack(args[0])
+	if err != nil {
+		return err
+	}
+	logrus.Debug("Linting stack...")
+	diags := parsedStack.Lint(config)
+	logDiagnostics(diags)
+	if diags.HasErrors() {
+		return fmt.Errorf("stack file %q has %d lint error(s)", args[0], diags.Count(stack.SeverityError))
+	}
+	if len(diags) == 0 {
+		logrus.Infof("Stack file %q has no lint problems!", args[0])
+	}
+	return nil
+}
+
+// Reads the lint config. A missing default config file means every rule runs
+// with its default settings.
+func (c *LintCmd) loadConfig() (stack.LintConfig, error) {
+	filename := c.Config
+	if filename == "" {
+		filename = stack.LintConfigFile
+	}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) && c.Config == "" {
+		return stack.LintConfig{}, nil
+	}
+	if err != nil {
+		return stack.LintConfig{}, fmt.Errorf("failed to read lint config: %v", err)
+	}
+	logrus.Debugf("Using lint config %q", filename)
+	config, err := stack.ParseLintConfig(data)
+	if err != nil {
+		return config, fmt.Errorf("%s: %v", filename, strings.TrimPrefix(err.Error(), stack.ErrInvalidLintConfig.Error()+": "))
+	}
+	return config, nil
+}
@@ -0,0 +1,35 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/sirupsen/logrus"
+)
+
+// Loads a stack template file and everything it includes from disk. Files
+// below the working directory are loaded relative to it, so that positions
+// show the path the file was given as, and they can include any file below
+// it. Other files can only include files in their own directory and below.
+func loadStack(filename string) (*stack.Stack, error) {
+	dir, name := ".", filepath.ToSlash(filepath.Clean(filename))
+	if !filepath.IsLocal(filename) {
+		dir, name = filepath.Dir(filename), filepath.Base(filename)
+	}
+	logrus.Debug("Parsing stack...")
+	s, err := stack.Load(os.DirFS(dir), name)
+	if errors.Is(err, fs.ErrNotExist) && !errors.As(err, new(*stack.PosError)) {
+		return nil, fmt.Errorf("file does not exist")
+	}
+	if errors.Is(err, stack.ErrIncludeOutsideRoot) {
+		return nil, fmt.Errorf("failed to parse stack template: %v (files can only be included from %q and below; run groundctl from a directory that contains all included files)", err, dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stack template: %v", err)
+	}
+	return s, nil
+}
@@ -1,9 +1,7 @@
 package stack
 
 import (
-	"errors"
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 
@@ -12,31 +10,60 @@ import (
 	"github.com/spf13/cobra"
 )
 
-type PreviewCmd struct{}
+type PreviewCmd struct {
+	Inputs InputFlags
+	// Prompt for missing secret values, which are never shown but can change
+	// the conditions and expansions of steps
+	PromptSecrets bool
+}
 
 func (c *PreviewCmd) Run(cmd *cobra.Command, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("accepts 1 arg(s), received %d", len(args))
 	}
-	// Read the template file
-	templateBytes, err := os.ReadFile(args[0])
+	// Read and parse the template file
+	parsedStack, err := loadStack(args[0])
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return fmt.Errorf("file does not exist")
-		}
-		return fmt.Errorf("failed to read file: %v", err)
+		return err
 	}
-	// Parse as a template
-	logrus.Debug("Parsing stack...")
-	parsedStack, err := stack.Parse(templateBytes)
-	if err != nil {
-		return fmt.Errorf("failed to parse stack template: %v", err)
+	// Resolve the stack if any input values were given, or can be prompted for.
+	// Values derived from secrets are never shown, and registered variables
+	// aren't known until deployment.
+	var resolved *stack.ResolvedStack
+	if c.Inputs.Wanted(parsedStack) {
+		logrus.Debug("Loading inputs...")
+		inputs, err := c.Inputs.Load(parsedStack)
+		if err != nil {
+			return fmt.Errorf("invalid inputs: %v", err)
+		}
+		secrets, err := c.Inputs.LoadSecrets(parsedStack, c.PromptSecrets)
+		if err != nil {
+			return fmt.Errorf("invalid secrets: %v", err)
+		}
+		for name := range parsedStack.Secrets {
+			if _, ok := secrets[name]; !ok {
+				secrets[name] = maskedSecret
+			}
+		}
+		logrus.Debug("Resolving stack...")
+		resolved, err = parsedStack.ResolveStack(inputs, secrets, stack.ResolveOptions{Lenient: true})
+		if err != nil {
+			return fmt.Errorf("failed to resolve stack: %v", err)
+		}
 	}
-	outputPreview(parsedStack)
+	outputPreview(parsedStack, resolved)
 	return nil
 }
 
-func outputPreview(s *stack.Stack) {
+// Shown in place of secret values
+const maskedSecret = "********"
+
+func outputPreview(s *stack.Stack, resolved *stack.ResolvedStack) {
+	var inputs map[string]any
+	if resolved != nil {
+		inputs = resolved.Inputs
+	}
+
 	fmt.Printf("Stack Plan Preview: %s (version: %s)\n", s.DisplayName, s.Version)
 	if s.Description != "" {
 		fmt.Printf("Description: %s\n", s.Description)
@@ -45,7 +72,9 @@ func outputPreview(s *stack.Stack) {
 
 	// Provider
 	fmt.Printf("\n🔌 Provider: %s\n", s.Provider.Type)
-	if len(s.Provider.Properties) > 0 {
+	if resolved != nil {
+		outputResolvedValues("  ", resolved.Provider.Properties, resolved.Provider.Sources, resolved.Provider.Sensitive)
+	} else if len(s.Provider.Properties) > 0 {
 		keys := sortedKeys(s.Provider.Properties)
 		for _, k := range keys {
 			fmt.Printf("  - %s: %s\n", k, s.Provider.Properties[k])
@@ -66,6 +95,9 @@ func outputPreview(s *stack.Stack) {
 				fmt.Printf(" [default: %v]", input.Default)
 			}
 			fmt.Println()
+			if val, ok := inputs[name]; ok {
+				fmt.Printf("      → Value: %v\n", val)
+			}
 			if input.Label != "" {
 				fmt.Printf("      → Label: %s\n", input.Label)
 			}
@@ -103,12 +135,43 @@ func outputPreview(s *stack.Stack) {
 		}
 	}
 
+	// Locals
+	if len(s.Locals) > 0 {
+		fmt.Println("\n🧮 Locals:")
+		for _, name := range sortedKeys(s.Locals) {
+			fmt.Printf("  - %s = %v\n", name, s.Locals[name])
+			if resolved != nil && resolved.SensitiveLocals[name] {
+				fmt.Printf("      → Value: %s\n", sensitiveValue)
+			} else if resolved != nil {
+				fmt.Printf("      → Value: %v\n", resolved.Locals[name])
+			}
+		}
+	}
+
+	// Dependencies are only shown when the graph can be built
+	var nodes map[*stack.Step]*stack.Node
+	graph, err := s.Graph()
+	if err == nil {
+		nodes = make(map[*stack.Step]*stack.Node, len(graph.Nodes))
+		for _, n := range graph.Nodes {
+			nodes[n.Step] = n
+		}
+	}
+
 	// Layers & Steps
 	if len(s.Layers) > 0 {
 		fmt.Println("\n🧱 Layers:")
 		for i, layer := range s.Layers {
-			fmt.Printf("  %d. %s\n", i+1, layer.Name)
-			for j, step := range layer.Steps {
+			if resolved != nil && resolved.Layers[i].Skipped {
+				fmt.Printf("  %d. %s (skipped)\n", i+1, layer.Name)
+			} else {
+				fmt.Printf("  %d. %s\n", i+1, layer.Name)
+			}
+			if layer.When != "" {
+				fmt.Printf("     → when: %s\n", layer.When)
+			}
+			for j := range layer.Steps {
+				step := &layer.Steps[j]
 				action := step.Action
 				if action == "" {
 					// fallback to raw action key
@@ -117,14 +180,77 @@ func outputPreview(s *stack.Stack) {
 						break
 					}
 				}
-				fmt.Printf("     %d.%d Step: %s [%s]\n", i+1, j+1, step.Name, action)
+				// The resolved step, or the instances of an expanded step
+				var instances []stack.ResolvedStep
+				if resolved != nil {
+					for _, resolvedStep := range resolved.Layers[i].Steps {
+						if resolvedStep.Index == j {
+							instances = append(instances, resolvedStep)
+						}
+					}
+				}
+				expanded := len(instances) > 0 && instances[0].Key != nil
+				if len(instances) == 1 && !expanded && instances[0].Skipped {
+					fmt.Printf("     %d.%d Step: %s [%s] (skipped)\n", i+1, j+1, step.Name, action)
+				} else {
+					fmt.Printf("     %d.%d Step: %s [%s]\n", i+1, j+1, step.Name, action)
+				}
+				if step.ForEach != nil {
+					fmt.Printf("       → for_each: %v\n", step.ForEach)
+				}
+				if step.Count != nil {
+					fmt.Printf("       → count: %v\n", step.Count)
+				}
+				if step.When != "" {
+					fmt.Printf("       → when: %s\n", step.When)
+				}
 
+				if n := nodes[step]; n != nil && len(n.DependsOn) > 0 {
+					deps := make([]string, 0, len(n.DependsOn))
+					for _, dep := range n.DependsOn {
+						if dep.Variable != "" {
+							deps = append(deps, fmt.Sprintf("%s (%s)", dep.Node, dep.Variable))
+						} else {
+							deps = append(deps, dep.Node.String())
+						}
+					}
+					fmt.Printf("       → depends on: %s\n", strings.Join(deps, ", "))
+				}
 				if step.Register != "" {
 					fmt.Printf("       → registers: %s\n", step.Register)
 				}
 				if len(step.Tags) > 0 {
 					fmt.Printf("       Tags: %v\n", step.Tags)
 				}
+				for _, inst := range instances {
+					switch {
+					case expanded && inst.Skipped:
+						fmt.Printf("       Instance: %s (skipped)\n", inst.Name)
+					case expanded:
+						fmt.Printf("       Instance: %s\n", inst.Name)
+						outputResolvedValues("         ", inst.Params, inst.Sources, inst.Sensitive)
+					case !inst.Skipped:
+						fmt.Println("       Params:")
+						outputResolvedValues("         ", inst.Params, inst.Sources, inst.Sensitive)
+					}
+				}
+				if resolved != nil && len(instances) == 0 && !resolved.Layers[i].Skipped {
+					fmt.Println("       No instances")
+				}
+			}
+		}
+	}
+
+	// Steps that don't depend on each other can run together
+	if graph != nil {
+		if levels, err := graph.Levels(); err == nil && len(levels) > 0 {
+			fmt.Println("\n⏱️  Run order:")
+			for i, level := range levels {
+				names := make([]string, len(level))
+				for j, n := range level {
+					names[j] = n.Step.Name
+				}
+				fmt.Printf("  %d. %s\n", i+1, strings.Join(names, ", "))
 			}
 		}
 	}
@@ -135,7 +261,14 @@ func outputPreview(s *stack.Stack) {
 		keys := sortedKeys(s.Outputs)
 		for _, name := range keys {
 			out := s.Outputs[name]
-			fmt.Printf("  - %s = %s\n", name, out.Value)
+			fmt.Printf("  - %s = %v", name, out.Value)
+			if out.Type != "" {
+				fmt.Printf(" (%s)", out.Type)
+			}
+			if out.Sensitive {
+				fmt.Print(" [sensitive]")
+			}
+			fmt.Println()
 			if out.Description != "" {
 				fmt.Printf("      → %s\n", out.Description)
 			}
@@ -143,6 +276,84 @@ func outputPreview(s *stack.Stack) {
 	}
 }
 
+// Shown in place of values derived from secrets
+const sensitiveValue = "(sensitive)"
+
+// Prints each resolved value on its own line, along with where it came from.
+// Values derived from secrets are hidden.
+func outputResolvedValues(indent string, values map[string]any, sources map[string][]stack.Source, sensitive map[string]bool) {
+	flat := make(map[string]any)
+	flattenValues("", values, flat)
+	for _, path := range sortedKeys(flat) {
+		if isSensitive(sensitive, path) {
+			fmt.Printf("%s- %s: %s", indent, path, sensitiveValue)
+		} else {
+			fmt.Printf("%s- %s: %v", indent, path, flat[path])
+		}
+		var from []string
+		for _, src := range nearestSources(sources, path) {
+			if src.Kind != stack.SourceLiteral {
+				from = append(from, src.String())
+			}
+		}
+		if len(from) > 0 {
+			fmt.Printf(" (from %s)", strings.Join(from, ", "))
+		}
+		fmt.Println()
+	}
+}
+
+// Returns the sources of a path, or of its closest parent. Values like lists
+// that come from a single template only have sources recorded for the whole value.
+func nearestSources(sources map[string][]stack.Source, path string) []stack.Source {
+	for path != "" {
+		if srcs, ok := sources[path]; ok {
+			return srcs
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return nil
+}
+
+// Reports whether a path, or any of its parents, is derived from secrets
+func isSensitive(sensitive map[string]bool, path string) bool {
+	for path != "" {
+		if sensitive[path] {
+			return true
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return false
+}
+
+// Flattens nested maps and lists into a map keyed by path, e.g. "ingress[0].from_port"
+func flattenValues(path string, val any, out map[string]any) {
+	switch v := val.(type) {
+	case map[string]any:
+		for k, item := range v {
+			if path == "" {
+				flattenValues(k, item, out)
+			} else {
+				flattenValues(path+"."+k, item, out)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			flattenValues(fmt.Sprintf("%s[%d]", path, i), item, out)
+		}
+	default:
+		out[path] = v
+	}
+}
+
 func sortedKeys[K comparable, V any](m map[K]V) []K {
 	keys := make([]K, 0, len(m))
 	for k := range m {
@@ -0,0 +1,200 @@
+package stack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"golang.org/x/term"
+)
+
+var errEmptyValue = errors.New("a value is required")
+
+// readError is a failure to read from the terminal, as opposed to an invalid
+// value that can be prompted for again
+type readError struct {
+	err error
+}
+
+func (e readError) Error() string {
+	return e.err.Error()
+}
+
+func (e readError) Unwrap() error {
+	return e.err
+}
+
+// Reports whether stdin is an interactive terminal that can be prompted
+func isInteractive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// prompter asks for stack input and secret values on a terminal
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	// Reads a line without echoing it back
+	readHidden func() (string, error)
+}
+
+// Returns a prompter that reads from stdin and writes prompts to stderr, so
+// that prompts never end up in the command's output
+func newTerminalPrompter() *prompter {
+	return &prompter{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stderr,
+		readHidden: func() (string, error) {
+			line, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			return string(line), err
+		},
+	}
+}
+
+// Prompts for the value of an input until a valid one is given. Inputs with an
+// allowed list are shown as a numbered selection.
+func (p *prompter) promptInput(s *stack.Stack, name string) (any, error) {
+	input := s.Inputs[name]
+	p.header(name, input.Type, input.Label, input.Description)
+	for {
+		var val any
+		var err error
+		if len(input.Allowed) > 0 {
+			val, err = p.selectValue(input.Allowed, input.Default)
+		} else {
+			val, err = p.readValue(s, name, input.Default)
+		}
+		if err == nil {
+			if val, err = input.Convert(val); err == nil {
+				err = input.CheckAllowed(val)
+			}
+		}
+		if err == nil {
+			return val, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("no value given for input '%s'", name)
+		}
+		if errors.As(err, new(readError)) {
+			return nil, fmt.Errorf("failed to read input '%s': %w", name, err)
+		}
+		fmt.Fprintf(p.out, "  ✗ %v\n", err)
+	}
+}
+
+// Prompts for the value of a secret without echoing it
+func (p *prompter) promptSecret(name string, secret stack.Secret) (string, error) {
+	p.header(name, secret.Type, secret.Label, secret.Description)
+	for {
+		var val string
+		var err error
+		if len(secret.Allowed) > 0 {
+			var selected any
+			if selected, err = p.selectValue(secret.Allowed, nil); err == nil {
+				val = fmt.Sprint(selected)
+			}
+		} else {
+			fmt.Fprint(p.out, "  > ")
+			if val, err = p.readHidden(); err != nil {
+				err = readError{err}
+			} else if val == "" {
+				err = errEmptyValue
+			}
+		}
+		if err == nil {
+			return val, nil
+		}
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("no value given for secret '%s'", name)
+		}
+		if errors.As(err, new(readError)) {
+			return "", fmt.Errorf("failed to read secret '%s': %w", name, err)
+		}
+		fmt.Fprintf(p.out, "  ✗ %v\n", err)
+	}
+}
+
+func (p *prompter) header(name, typ, label, description string) {
+	if label != "" {
+		fmt.Fprintf(p.out, "%s (%s, %s)\n", label, name, typ)
+	} else {
+		fmt.Fprintf(p.out, "%s (%s)\n", name, typ)
+	}
+	if description != "" {
+		fmt.Fprintf(p.out, "  %s\n", description)
+	}
+}
+
+// Reads a free-form value, falling back to the default when nothing is entered
+func (p *prompter) readValue(s *stack.Stack, name string, def any) (any, error) {
+	if def != nil {
+		fmt.Fprintf(p.out, "  [%v] > ", def)
+	} else {
+		fmt.Fprint(p.out, "  > ")
+	}
+	line, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		if def != nil {
+			return def, nil
+		}
+		return nil, errEmptyValue
+	}
+	return parseInputValue(s, name, line)
+}
+
+// Lists the allowed values and reads a choice, either by number or by value
+func (p *prompter) selectValue(allowed []stack.AllowedValue, def any) (any, error) {
+	defIndex := 0
+	for i, option := range allowed {
+		marker := " "
+		if def != nil && fmt.Sprint(option.Value) == fmt.Sprint(def) {
+			marker = "*"
+			defIndex = i + 1
+		}
+		if option.Label != "" {
+			fmt.Fprintf(p.out, "  %s %d) %s [%v]\n", marker, i+1, option.Label, option.Value)
+		} else {
+			fmt.Fprintf(p.out, "  %s %d) %v\n", marker, i+1, option.Value)
+		}
+	}
+	if defIndex > 0 {
+		fmt.Fprintf(p.out, "  Select 1-%d [%d] > ", len(allowed), defIndex)
+	} else {
+		fmt.Fprintf(p.out, "  Select 1-%d > ", len(allowed))
+	}
+	line, err := p.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		if defIndex > 0 {
+			return allowed[defIndex-1].Value, nil
+		}
+		return nil, errEmptyValue
+	}
+	if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(allowed) {
+		return allowed[n-1].Value, nil
+	}
+	for _, option := range allowed {
+		if fmt.Sprint(option.Value) == line {
+			return option.Value, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not one of the options", line)
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", readError{err}
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,142 @@
+package stack
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns a prompter that reads the given lines and writes prompts to out.
+// Hidden values are read from hidden, one per call.
+func testPrompter(input string, out io.Writer, hidden ...string) *prompter {
+	return &prompter{
+		in:  bufio.NewReader(strings.NewReader(input)),
+		out: out,
+		readHidden: func() (string, error) {
+			if len(hidden) == 0 {
+				return "", io.EOF
+			}
+			val := hidden[0]
+			hidden = hidden[1:]
+			return val, nil
+		},
+	}
+}
+
+const promptStack = `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    label: Region
+    default: eu-west-1
+    allowed:
+      - label: US
+        value: us-east-1
+      - label: EU
+        value: eu-west-1
+  size:
+    type: integer
+    required: true
+    description: Number of instances
+  zone:
+    type: string
+    default: a
+secrets:
+  api_key:
+    type: string
+    label: API key
+layers: []
+`
+
+func TestPrompter(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(promptStack))
+	require.NoError(t, err)
+
+	t.Run("select by index", func(t *testing.T) {
+		var out strings.Builder
+		val, err := testPrompter("1\n", &out).promptInput(s, "region")
+		require.NoError(t, err)
+		assert.Equal(t, "us-east-1", val)
+		assert.Contains(t, out.String(), "Region (region, string)\n")
+		assert.Contains(t, out.String(), "    1) US [us-east-1]\n  * 2) EU [eu-west-1]\n  Select 1-2 [2] > ")
+	})
+
+	t.Run("select by value", func(t *testing.T) {
+		val, err := testPrompter("eu-west-1\n", io.Discard).selectValue(s.Inputs["region"].Allowed, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "eu-west-1", val)
+
+		_, err = testPrompter("ap-south-1\n", io.Discard).selectValue(s.Inputs["region"].Allowed, nil)
+		assert.EqualError(t, err, `"ap-south-1" is not one of the options`)
+	})
+
+	t.Run("defaults on empty input", func(t *testing.T) {
+		var out strings.Builder
+		val, err := testPrompter("\n", &out).promptInput(s, "zone")
+		require.NoError(t, err)
+		assert.Equal(t, "a", val)
+		assert.Contains(t, out.String(), "  [a] > ")
+
+		val, err = testPrompter("\n", io.Discard).promptInput(s, "region")
+		require.NoError(t, err)
+		assert.Equal(t, "eu-west-1", val)
+	})
+
+	t.Run("re-prompts after an invalid value", func(t *testing.T) {
+		var out strings.Builder
+		val, err := testPrompter("abc\n\n3\n", &out).promptInput(s, "size")
+		require.NoError(t, err)
+		assert.Equal(t, 3, val)
+		assert.Contains(t, out.String(), "  Number of instances\n")
+		assert.Contains(t, out.String(), `  ✗ expected integer, got string "abc"`+"\n")
+		assert.Contains(t, out.String(), "  ✗ a value is required\n")
+		assert.Equal(t, 3, strings.Count(out.String(), "  > "))
+	})
+
+	t.Run("no more input", func(t *testing.T) {
+		_, err := testPrompter("abc\n", io.Discard).promptInput(s, "size")
+		assert.EqualError(t, err, "no value given for input 'size'")
+	})
+
+	t.Run("read errors aren't retried", func(t *testing.T) {
+		errRead := errors.New("input/output error")
+		var out strings.Builder
+		p := &prompter{
+			in:         bufio.NewReader(iotest.ErrReader(errRead)),
+			out:        &out,
+			readHidden: func() (string, error) { return "", errRead },
+		}
+		_, err := p.promptInput(s, "size")
+		assert.ErrorIs(t, err, errRead)
+		assert.EqualError(t, err, "failed to read input 'size': input/output error")
+		_, err = p.promptInput(s, "region")
+		assert.ErrorIs(t, err, errRead)
+		_, err = p.promptSecret("api_key", s.Secrets["api_key"])
+		assert.EqualError(t, err, "failed to read secret 'api_key': input/output error")
+		assert.NotContains(t, out.String(), "✗")
+	})
+
+	t.Run("secrets are read hidden", func(t *testing.T) {
+		var out strings.Builder
+		val, err := testPrompter("", &out, "", "hunter2").promptSecret("api_key", s.Secrets["api_key"])
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2", val)
+		assert.Contains(t, out.String(), "API key (api_key, string)\n")
+		assert.Contains(t, out.String(), "  ✗ a value is required\n")
+		assert.NotContains(t, out.String(), "hunter2")
+
+		_, err = testPrompter("", io.Discard).promptSecret("api_key", s.Secrets["api_key"])
+		assert.EqualError(t, err, "no value given for secret 'api_key'")
+	})
+}
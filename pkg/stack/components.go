@@ -0,0 +1,230 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// componentUse is a step that uses a component. The step is replaced by a copy
+// of each of the component's steps, which all point back to it.
+type componentUse struct {
+	// The step with "use", as it was defined
+	step Step
+	// Name of the component and its definition
+	name      string
+	component Component
+	// Prefix of the variables registered by the component's steps, which is
+	// the variable registered by the using step, or the component name
+	namespace string
+}
+
+// Replaces every step that uses a component with copies of the component's
+// steps. Each copy is named after the using step and the component step, like
+// "Network/Create VPC", and registers its variable in the namespace of the use,
+// like "network.vpc". Components can't use other components.
+func (s *Stack) expandComponents() error {
+	for _, name := range sortedKeys(s.Components) {
+		comp := s.Components[name]
+		for _, step := range comp.Steps {
+			if step.Use != "" {
+				return errorAt(step.positions.at("use", step.Pos), "step '%s' in component '%s' can't use another component", step.Name, name)
+			}
+		}
+		// Check the component's own registered variables
+		local := &Stack{Layers: []Layer{{Steps: comp.Steps}}}
+		if err := local.registerVariables(); err != nil {
+			return err
+		}
+	}
+
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		var steps []Step
+		for _, step := range layer.Steps {
+			if step.Use == "" {
+				steps = append(steps, step)
+				continue
+			}
+			comp, ok := s.Components[step.Use]
+			if !ok {
+				return errorAt(step.positions.at("use", step.Pos), "step '%s' in layer '%s' uses unknown component '%s'", step.Name, layer.Name, step.Use)
+			}
+			if step.ForEach != nil || step.Count != nil {
+				return errorAt(step.Pos, "step '%s' in layer '%s' can't use a component with for_each or count", step.Name, layer.Name)
+			}
+			use := &componentUse{step: step, name: step.Use, component: comp, namespace: step.Register}
+			if use.namespace == "" {
+				use.namespace = step.Use
+			}
+			logrus.WithField("step", step.Name).Tracef("Using component %q", step.Use)
+			for _, inner := range comp.Steps {
+				c := inner
+				c.Name = step.Name + "/" + inner.Name
+				if inner.Register != "" {
+					c.Register = use.namespace + "." + inner.Register
+				}
+				c.DependsOn = make([]string, len(inner.DependsOn))
+				for k, dep := range inner.DependsOn {
+					c.DependsOn[k] = step.Name + "/" + dep
+				}
+				c.Tags = append(append([]string{}, step.Tags...), inner.Tags...)
+				c.use = use
+				steps = append(steps, c)
+			}
+		}
+		layer.Steps = steps
+	}
+	return nil
+}
+
+// Returns a stack with the inputs of a component and a single layer with its
+// steps, to validate the component like any other stack
+func (s *Stack) componentStack(name string) *Stack {
+	comp := s.Components[name]
+	cs := &Stack{
+		Name:                s.Name,
+		Inputs:              comp.Inputs,
+		Secrets:             s.Secrets,
+		Locals:              s.Locals,
+		Layers:              []Layer{{Name: name, Steps: comp.Steps, Pos: comp.Pos, component: true}},
+		RegisteredVariables: make(map[string]map[string]any),
+	}
+	for _, step := range comp.Steps {
+		if step.Register != "" {
+			cs.RegisteredVariables[step.Register] = make(map[string]any)
+		}
+	}
+	return cs
+}
+
+// Returns the name of the registered variable a reference points into, which
+// is the longest prefix of the reference that names one. The variables of
+// component steps have dotted names, like "network.vpc".
+func registeredName[V any](vars map[string]V, parts []string) (string, bool) {
+	for i := len(parts); i > 0; i-- {
+		name := strings.Join(parts[:i], ".")
+		if _, ok := vars[name]; ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Reports whether name is the namespace of the variables registered by the steps
+// of a component
+func (s *Stack) isNamespace(name string) bool {
+	for registered := range s.RegisteredVariables {
+		if strings.HasPrefix(registered, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the stack holds the steps of a component for validation
+func (s *Stack) isComponent() bool {
+	return len(s.Layers) == 1 && s.Layers[0].component
+}
+
+// Describes the layer in messages. The steps of components are validated as a
+// layer named after the component.
+func (l *Layer) describe() string {
+	if l.component {
+		return "component '" + l.Name + "'"
+	}
+	return "layer '" + l.Name + "'"
+}
+
+// Validates every component like the steps of a layer, and checks the
+// arguments and conditions of the steps that use components
+func (s *Stack) validateComponents(diags *Diagnostics) {
+	logrus.WithField("stack", s.Name).Trace("Validating stack components")
+	for _, name := range sortedKeys(s.Components) {
+		cs := s.componentStack(name)
+		cs.validateInputs(diags)
+		cs.validateLayers(diags)
+		cs.validateTemplates(diags)
+		cs.validateDependencies(diags)
+	}
+
+	registered := s.alwaysRegistered()
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		var last *componentUse
+		for _, step := range layer.Steps {
+			if step.use == nil || step.use == last {
+				continue
+			}
+			last = step.use
+			s.validateUse(diags, layer, step.use, registered)
+		}
+	}
+}
+
+// Checks the arguments given to a component against its inputs, and the
+// templates of the using step, which are evaluated outside of the component
+func (s *Stack) validateUse(diags *Diagnostics, layer *Layer, use *componentUse, registered map[string]bool) {
+	step := &use.step
+	where := fmt.Sprintf("step '%s' in %s", step.Name, layer.describe())
+	if step.Name == "" {
+		diags.errorf(step.Pos, CodeStepMissingFields, "step in %s is missing name or action", layer.describe())
+	}
+	for _, key := range sortedKeys(step.With) {
+		input, ok := use.component.Inputs[key]
+		at := step.positions.at("with."+key, step.Pos)
+		if !ok {
+			diags.errorf(at, CodeComponentInput, "%s sets unknown input '%s' of component '%s'", where, key, use.name)
+			continue
+		}
+		// Only values without templates are known before resolving
+		val := step.With[key]
+		if containsTemplate(val) {
+			continue
+		}
+		if t, err := ParseType(input.Type); err == nil {
+			if _, err := t.Convert(val); err != nil {
+				diags.errorf(at, CodeComponentInput, "%s sets input '%s' of component '%s' to an invalid %s: %v", where, key, use.name, t, err)
+			}
+		}
+	}
+	for _, name := range sortedKeys(use.component.Inputs) {
+		input := use.component.Inputs[name]
+		if _, ok := step.With[name]; !ok && (input.Required || input.Default == nil) {
+			diags.errorf(step.positions.at("use", step.Pos), CodeComponentInput, "%s is missing input '%s' of component '%s'", where, name, use.name)
+		}
+	}
+
+	check := templateCheck{
+		context:     "invalid template in " + where,
+		where:       where,
+		use:         use,
+		conditional: layer.When != "",
+		registered:  registered,
+	}
+	s.checkTemplates(diags, check, step.With, "with", step.positions, step.Pos)
+	check.conditional = true
+	s.checkTemplates(diags, check, step.When, "when", step.positions, step.Pos)
+}
+
+// Reports whether a value has a template anywhere below it
+func containsTemplate(val any) bool {
+	switch v := val.(type) {
+	case string:
+		return strings.Contains(v, "{{")
+	case map[string]any:
+		for _, item := range v {
+			if containsTemplate(item) {
+				return true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if containsTemplate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Names of the constraints that can be declared on an input
+const (
+	ConstraintPattern   = "pattern"
+	ConstraintMin       = "min"
+	ConstraintMax       = "max"
+	ConstraintMinLength = "min_length"
+	ConstraintMaxLength = "max_length"
+	ConstraintUnique    = "unique"
+)
+
+// ConstraintError is returned when a value breaks one of an input's constraints
+type ConstraintError struct {
+	Constraint string
+	Message    string
+}
+
+func (e *ConstraintError) Error() string {
+	return e.Message
+}
+
+// Returns the names of all constraints declared on the input
+func (i Input) constraints() []string {
+	var names []string
+	if i.Pattern != "" {
+		names = append(names, ConstraintPattern)
+	}
+	if i.Min != nil {
+		names = append(names, ConstraintMin)
+	}
+	if i.Max != nil {
+		names = append(names, ConstraintMax)
+	}
+	if i.MinLength != nil {
+		names = append(names, ConstraintMinLength)
+	}
+	if i.MaxLength != nil {
+		names = append(names, ConstraintMaxLength)
+	}
+	if i.Unique {
+		names = append(names, ConstraintUnique)
+	}
+	return names
+}
+
+// Checks that the declared constraints make sense for the input's type
+func (i Input) validateConstraints(t Type) []error {
+	var errs []error
+	for _, name := range i.constraints() {
+		if !constraintApplies(name, t) {
+			errs = append(errs, fmt.Errorf("constraint '%s' cannot be used with type %s", name, t))
+		}
+	}
+	if i.Pattern != "" {
+		if _, err := regexp.Compile(i.Pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern: %v", err))
+		}
+	}
+	if i.Min != nil && i.Max != nil && *i.Min > *i.Max {
+		errs = append(errs, fmt.Errorf("min %v is greater than max %v", *i.Min, *i.Max))
+	}
+	if i.MinLength != nil && *i.MinLength < 0 {
+		errs = append(errs, fmt.Errorf("min_length must not be negative"))
+	}
+	if i.MaxLength != nil && *i.MaxLength < 0 {
+		errs = append(errs, fmt.Errorf("max_length must not be negative"))
+	}
+	if i.MinLength != nil && i.MaxLength != nil && *i.MinLength > *i.MaxLength {
+		errs = append(errs, fmt.Errorf("min_length %d is greater than max_length %d", *i.MinLength, *i.MaxLength))
+	}
+	for name := range i.Messages {
+		if !isConstraintName(name) {
+			errs = append(errs, fmt.Errorf("message given for unknown constraint '%s'", name))
+		}
+	}
+	return errs
+}
+
+// Reports whether a constraint can be checked against values of type t. Pattern
+// and min/max also apply to each element of a list.
+func constraintApplies(name string, t Type) bool {
+	elem := t
+	if list, ok := t.(ListType); ok {
+		elem = list.Elem
+	}
+	switch name {
+	case ConstraintPattern:
+		return elem == TypeString
+	case ConstraintMin, ConstraintMax:
+		return elem == TypeNumber || elem == TypeInteger
+	case ConstraintMinLength, ConstraintMaxLength:
+		switch t.(type) {
+		case ListType, MapType:
+			return true
+		}
+		return t == TypeString
+	case ConstraintUnique:
+		_, ok := t.(ListType)
+		return ok
+	}
+	return false
+}
+
+func isConstraintName(name string) bool {
+	switch name {
+	case ConstraintPattern, ConstraintMin, ConstraintMax, ConstraintMinLength, ConstraintMaxLength, ConstraintUnique:
+		return true
+	}
+	return false
+}
+
+// CheckConstraints checks a value, already converted to the input's type, against
+// all of the input's constraints. Every broken constraint is returned as a
+// *ConstraintError, using the custom message if one was given.
+func (i Input) CheckConstraints(val any) error {
+	var errs []error
+	fail := func(constraint, format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		if custom, ok := i.Messages[constraint]; ok {
+			msg = custom
+		}
+		errs = append(errs, &ConstraintError{Constraint: constraint, Message: msg})
+	}
+
+	// Pattern and min/max are checked against every element of a list
+	elems := []any{val}
+	if list, ok := val.([]any); ok {
+		elems = list
+	}
+	if i.Pattern != "" {
+		re, err := regexp.Compile(i.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		for _, elem := range elems {
+			if s, ok := elem.(string); ok && !re.MatchString(s) {
+				fail(ConstraintPattern, "value %q does not match pattern '%s'", s, i.Pattern)
+			}
+		}
+	}
+	for _, elem := range elems {
+		n, ok := toFloat(elem)
+		if !ok {
+			continue
+		}
+		if i.Min != nil && n < *i.Min {
+			fail(ConstraintMin, "value %v is less than the minimum of %v", elem, *i.Min)
+		}
+		if i.Max != nil && n > *i.Max {
+			fail(ConstraintMax, "value %v is greater than the maximum of %v", elem, *i.Max)
+		}
+	}
+
+	if length, ok := valueLength(val); ok {
+		if i.MinLength != nil && length < *i.MinLength {
+			fail(ConstraintMinLength, "length %d is less than the minimum length of %d", length, *i.MinLength)
+		}
+		if i.MaxLength != nil && length > *i.MaxLength {
+			fail(ConstraintMaxLength, "length %d is greater than the maximum length of %d", length, *i.MaxLength)
+		}
+	}
+
+	if list, ok := val.([]any); ok && i.Unique {
+		var seen, repeated []any
+		for _, elem := range list {
+			if containsValue(seen, elem) && !containsValue(repeated, elem) {
+				fail(ConstraintUnique, "value %v appears more than once", elem)
+				repeated = append(repeated, elem)
+			}
+			seen = append(seen, elem)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func toFloat(val any) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// Returns the length of a string in characters, or the number of items in a list or map
+func valueLength(val any) (int, bool) {
+	switch v := val.(type) {
+	case string:
+		return utf8.RuneCountInString(v), true
+	case []any:
+		return len(v), true
+	case map[string]any:
+		return len(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,402 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FlowKind is the kind of element a node of the data flow graph stands for
+type FlowKind int
+
+const (
+	FlowInput FlowKind = iota
+	FlowSecret
+	FlowLocal
+	FlowStep
+	FlowOutput
+)
+
+func (k FlowKind) String() string {
+	switch k {
+	case FlowInput:
+		return "input"
+	case FlowSecret:
+		return "secret"
+	case FlowLocal:
+		return "local"
+	case FlowStep:
+		return "step"
+	case FlowOutput:
+		return "output"
+	}
+	return fmt.Sprintf("flow(%d)", int(k))
+}
+
+// DataFlow is the graph of how values flow through a stack: from inputs,
+// secrets and locals into locals, steps and outputs, and from the variables
+// registered by steps into other steps and outputs. Edges are taken from the
+// references of templates, and from depends_on between steps.
+type DataFlow struct {
+	// Inputs, secrets, locals, steps and outputs, in that order. Steps are in
+	// the order they are defined, the others by name.
+	Nodes []*FlowNode
+	Edges []FlowEdge
+}
+
+// FlowNode is an element of the stack in the data flow graph
+type FlowNode struct {
+	Kind FlowKind
+	Name string
+	// Layer of a step
+	Layer *Layer
+	// Position in DataFlow.Nodes
+	index int
+}
+
+// String describes the node, like "input 'region'"
+func (n *FlowNode) String() string {
+	return n.Kind.String() + " '" + n.Name + "'"
+}
+
+// ID returns the name the node can be found by, like "input.region". Steps
+// are found by their name.
+func (n *FlowNode) ID() string {
+	if n.Kind == FlowStep {
+		return n.Name
+	}
+	return n.Kind.String() + "." + n.Name
+}
+
+// FlowEdge is a flow of values from one node to another
+type FlowEdge struct {
+	From, To *FlowNode
+	// The registered variable a step's values flow through, or empty
+	Variable string
+	// Set when the edge is a depends_on between steps, which doesn't pass values
+	Explicit bool
+}
+
+// FlowDirection is the direction a closure of the data flow graph follows
+type FlowDirection int
+
+const (
+	// Everything a node's values come from
+	FlowUpstream FlowDirection = iota
+	// Everything a node's values flow into
+	FlowDownstream
+)
+
+// DataFlow builds the data flow graph of the stack. It fails like Graph if a
+// step depends on a step that doesn't exist.
+func (s *Stack) DataFlow() (*DataFlow, error) {
+	g, err := s.Graph()
+	if err != nil {
+		return nil, err
+	}
+	f := &DataFlow{}
+	byRef := make(map[string]*FlowNode)
+	add := func(kind FlowKind, name string, layer *Layer) *FlowNode {
+		n := &FlowNode{Kind: kind, Name: name, Layer: layer, index: len(f.Nodes)}
+		f.Nodes = append(f.Nodes, n)
+		return n
+	}
+	for _, name := range sortedKeys(s.Inputs) {
+		byRef["input."+name] = add(FlowInput, name, nil)
+	}
+	for _, name := range sortedKeys(s.Secrets) {
+		byRef["secret."+name] = add(FlowSecret, name, nil)
+	}
+	for _, name := range sortedKeys(s.Locals) {
+		byRef["local."+name] = add(FlowLocal, name, nil)
+	}
+	steps := make(map[*Node]*FlowNode, len(g.Nodes))
+	for _, gn := range g.Nodes {
+		steps[gn] = add(FlowStep, gn.Step.Name, gn.Layer)
+	}
+	outputs := make(map[string]*FlowNode, len(s.Outputs))
+	for _, name := range sortedKeys(s.Outputs) {
+		outputs[name] = add(FlowOutput, name, nil)
+	}
+
+	// Links a node to the inputs, secrets and locals referenced by vals
+	linkRoots := func(to *FlowNode, skipInputs bool, vals ...any) {
+		for _, val := range vals {
+			for _, parts := range templateReferences(val) {
+				if len(parts) < 2 || (skipInputs && parts[0] == "input") {
+					continue
+				}
+				if from, ok := byRef[parts[0]+"."+parts[1]]; ok {
+					f.addEdge(FlowEdge{From: from, To: to})
+				}
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.Locals) {
+		linkRoots(byRef["local."+name], false, s.Locals[name])
+	}
+	for _, gn := range g.Nodes {
+		to, step := steps[gn], gn.Step
+		// The inputs of a component are given by the step using it
+		linkRoots(to, step.use != nil, step.Name, step.Tags, step.Params, step.When, step.ForEach, step.Count, gn.Layer.When)
+		if use := step.use; use != nil {
+			linkRoots(to, false, use.step.Name, use.step.Tags, use.step.With, use.step.When)
+		}
+		for _, dep := range gn.DependsOn {
+			f.addEdge(FlowEdge{From: steps[dep.Node], To: to, Variable: dep.Variable, Explicit: dep.Variable == ""})
+		}
+	}
+	byVariable := make(map[string]*FlowNode)
+	for _, gn := range g.Nodes {
+		if gn.Step.Register != "" {
+			byVariable[gn.Step.Register] = steps[gn]
+		}
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		to := outputs[name]
+		linkRoots(to, false, s.Outputs[name].Value)
+		for _, parts := range templateReferences(s.Outputs[name].Value) {
+			if variable, ok := registeredName(byVariable, parts); ok {
+				f.addEdge(FlowEdge{From: byVariable[variable], To: to, Variable: variable})
+			}
+		}
+	}
+	return f, nil
+}
+
+// Adds an edge unless the nodes are already linked
+func (f *DataFlow) addEdge(e FlowEdge) {
+	for _, existing := range f.Edges {
+		if existing.From == e.From && existing.To == e.To {
+			return
+		}
+	}
+	f.Edges = append(f.Edges, e)
+}
+
+// Find returns the node with the given ID, like "input.region",
+// "output.vpc_id" or the name of a step, or nil if there is none
+func (f *DataFlow) Find(id string) *FlowNode {
+	for _, n := range f.Nodes {
+		if n.ID() == id || (n.Kind == FlowStep && "step."+n.Name == id) {
+			return n
+		}
+	}
+	return nil
+}
+
+// Closure returns the node and every node upstream or downstream of it
+func (f *DataFlow) Closure(n *FlowNode, dir FlowDirection) []*FlowNode {
+	seen := make([]bool, len(f.Nodes))
+	var closure []*FlowNode
+	var visit func(n *FlowNode)
+	visit = func(n *FlowNode) {
+		if seen[n.index] {
+			return
+		}
+		seen[n.index] = true
+		closure = append(closure, n)
+		for _, e := range f.Edges {
+			if dir == FlowUpstream && e.To == n {
+				visit(e.From)
+			} else if dir == FlowDownstream && e.From == n {
+				visit(e.To)
+			}
+		}
+	}
+	visit(n)
+	return closure
+}
+
+// RenderOptions changes how a data flow graph is rendered
+type RenderOptions struct {
+	// Name of the graph
+	Title string
+	// Node that is highlighted, along with the Highlight nodes
+	Focus *FlowNode
+	// Nodes and the edges between them that are highlighted, like the closure
+	// of the focus
+	Highlight []*FlowNode
+}
+
+// Colors of highlighted nodes and edges
+const (
+	focusColor     = "#ff8a65"
+	highlightColor = "#ffd54f"
+	edgeColor      = "#f57f17"
+)
+
+// Returns which nodes are highlighted, by index
+func (f *DataFlow) highlighted(opts RenderOptions) []bool {
+	highlighted := make([]bool, len(f.Nodes))
+	for _, n := range opts.Highlight {
+		highlighted[n.index] = true
+	}
+	if opts.Focus != nil {
+		highlighted[opts.Focus.index] = true
+	}
+	return highlighted
+}
+
+// Returns the steps grouped by layer, in the order the layers are defined
+func (f *DataFlow) layers() ([]*Layer, map[*Layer][]*FlowNode) {
+	var layers []*Layer
+	steps := make(map[*Layer][]*FlowNode)
+	for _, n := range f.Nodes {
+		if n.Kind != FlowStep {
+			continue
+		}
+		if _, ok := steps[n.Layer]; !ok {
+			layers = append(layers, n.Layer)
+		}
+		steps[n.Layer] = append(steps[n.Layer], n)
+	}
+	return layers, steps
+}
+
+// Returns the label of a node. Steps are labeled by name within their layer.
+func (n *FlowNode) label() string {
+	if n.Kind == FlowStep {
+		return n.Name
+	}
+	return n.Kind.String() + ": " + n.Name
+}
+
+// Shapes of the nodes in DOT
+var dotShapes = map[FlowKind]string{
+	FlowInput:  "ellipse",
+	FlowSecret: "octagon",
+	FlowLocal:  "note",
+	FlowStep:   "box",
+	FlowOutput: "parallelogram",
+}
+
+// DOT renders the graph in the Graphviz DOT language, with the steps of each
+// layer in a cluster
+func (f *DataFlow) DOT(opts RenderOptions) string {
+	highlighted := f.highlighted(opts)
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %s {\n", dotQuote(opts.Title))
+	b.WriteString("  rankdir=LR;\n")
+	node := func(indent string, n *FlowNode) {
+		attrs := fmt.Sprintf("label=%s, shape=%s", dotQuote(n.label()), dotShapes[n.Kind])
+		if n == opts.Focus {
+			attrs += fmt.Sprintf(", style=filled, fillcolor=%s", dotQuote(focusColor))
+		} else if highlighted[n.index] {
+			attrs += fmt.Sprintf(", style=filled, fillcolor=%s", dotQuote(highlightColor))
+		}
+		fmt.Fprintf(&b, "%sn%d [%s];\n", indent, n.index, attrs)
+	}
+	for _, n := range f.Nodes {
+		if n.Kind != FlowStep {
+			node("  ", n)
+		}
+	}
+	layers, steps := f.layers()
+	for i, layer := range layers {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", dotQuote(layer.describe()))
+		for _, n := range steps[layer] {
+			node("    ", n)
+		}
+		b.WriteString("  }\n")
+	}
+	for _, e := range f.Edges {
+		var attrs []string
+		if e.Variable != "" {
+			attrs = append(attrs, "label="+dotQuote(e.Variable))
+		}
+		if e.Explicit {
+			attrs = append(attrs, "style=dashed")
+		}
+		if highlighted[e.From.index] && highlighted[e.To.index] {
+			attrs = append(attrs, "color="+dotQuote(edgeColor), "penwidth=2")
+		}
+		fmt.Fprintf(&b, "  n%d -> n%d", e.From.index, e.To.index)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Quotes a string for DOT
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// Shapes of the nodes in Mermaid, as the brackets around their labels
+var mermaidShapes = map[FlowKind][2]string{
+	FlowInput:  {"([", "])"},
+	FlowSecret: {"{{", "}}"},
+	FlowLocal:  {"(", ")"},
+	FlowStep:   {"[", "]"},
+	FlowOutput: {"[/", "/]"},
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, with the steps of each
+// layer in a subgraph
+func (f *DataFlow) Mermaid(opts RenderOptions) string {
+	highlighted := f.highlighted(opts)
+	var b strings.Builder
+	if opts.Title != "" {
+		fmt.Fprintf(&b, "---\ntitle: %s\n---\n", mermaidQuote(opts.Title))
+	}
+	b.WriteString("flowchart LR\n")
+	node := func(indent string, n *FlowNode) {
+		shape := mermaidShapes[n.Kind]
+		fmt.Fprintf(&b, "%sn%d%s%s%s\n", indent, n.index, shape[0], mermaidQuote(n.label()), shape[1])
+	}
+	for _, n := range f.Nodes {
+		if n.Kind != FlowStep {
+			node("  ", n)
+		}
+	}
+	layers, steps := f.layers()
+	for i, layer := range layers {
+		fmt.Fprintf(&b, "  subgraph layer%d [%s]\n", i, mermaidQuote(layer.describe()))
+		for _, n := range steps[layer] {
+			node("    ", n)
+		}
+		b.WriteString("  end\n")
+	}
+	var highlightedEdges []string
+	for i, e := range f.Edges {
+		arrow := "-->"
+		if e.Explicit {
+			arrow = "-.->"
+		}
+		if e.Variable != "" {
+			arrow += "|" + mermaidQuote(e.Variable) + "|"
+		}
+		fmt.Fprintf(&b, "  n%d %s n%d\n", e.From.index, arrow, e.To.index)
+		if highlighted[e.From.index] && highlighted[e.To.index] {
+			highlightedEdges = append(highlightedEdges, fmt.Sprint(i))
+		}
+	}
+
+	var highlightedNodes []string
+	for _, n := range f.Nodes {
+		if highlighted[n.index] && n != opts.Focus {
+			highlightedNodes = append(highlightedNodes, fmt.Sprintf("n%d", n.index))
+		}
+	}
+	if len(highlightedNodes) > 0 {
+		fmt.Fprintf(&b, "  classDef highlight fill:%s\n", highlightColor)
+		fmt.Fprintf(&b, "  class %s highlight\n", strings.Join(highlightedNodes, ","))
+	}
+	if opts.Focus != nil {
+		fmt.Fprintf(&b, "  classDef focus fill:%s\n", focusColor)
+		fmt.Fprintf(&b, "  class n%d focus\n", opts.Focus.index)
+	}
+	if len(highlightedEdges) > 0 {
+		fmt.Fprintf(&b, "  linkStyle %s stroke:%s,stroke-width:2px\n", strings.Join(highlightedEdges, ","), edgeColor)
+	}
+	return b.String()
+}
+
+// Quotes a string for Mermaid, which escapes quotes as entities
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s) + `"`
+}
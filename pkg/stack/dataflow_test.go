@@ -0,0 +1,130 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const flowStack = `
+version: "1"
+name: web
+provider:
+  type: aws
+inputs:
+  cidr:
+    type: string
+    default: 10.0.0.0/16
+  size:
+    type: string
+    default: small
+secrets:
+  db_password:
+    type: string
+locals:
+  vpc_name: "web-{{ $.input.cidr }}"
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          cidr_block: "{{ $.input.cidr }}"
+          name: "{{ $.local.vpc_name }}"
+        register: vpc
+  - name: compute
+    steps:
+      - name: Launch Instance
+        aws.instance:
+          vpc_id: "{{ $.vpc.id }}"
+          size: "{{ $.input.size }}"
+        register: instance
+      - name: Create Database
+        aws.db:
+          password: "{{ $.secret.db_password }}"
+        depends_on: [Create VPC]
+outputs:
+  instance_id:
+    value: "{{ $.instance.id }}"
+`
+
+func flowEdges(f *stack.DataFlow) []string {
+	edges := make([]string, len(f.Edges))
+	for i, e := range f.Edges {
+		edges[i] = e.From.String() + " -> " + e.To.String()
+		if e.Variable != "" {
+			edges[i] += " (" + e.Variable + ")"
+		}
+		if e.Explicit {
+			edges[i] += " (depends_on)"
+		}
+	}
+	return edges
+}
+
+func flowIDs(nodes []*stack.FlowNode) []string {
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.ID()
+	}
+	return ids
+}
+
+func TestDataFlow(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(flowStack))
+	require.NoError(t, err)
+	f, err := s.DataFlow()
+	require.NoError(t, err)
+
+	t.Run("nodes and edges", func(t *testing.T) {
+		assert.Equal(t, []string{
+			"input.cidr", "input.size", "secret.db_password", "local.vpc_name",
+			"Create VPC", "Launch Instance", "Create Database", "output.instance_id",
+		}, flowIDs(f.Nodes))
+		assert.Equal(t, []string{
+			"input 'cidr' -> local 'vpc_name'",
+			"input 'cidr' -> step 'Create VPC'",
+			"local 'vpc_name' -> step 'Create VPC'",
+			"input 'size' -> step 'Launch Instance'",
+			"step 'Create VPC' -> step 'Launch Instance' (vpc)",
+			"secret 'db_password' -> step 'Create Database'",
+			"step 'Create VPC' -> step 'Create Database' (depends_on)",
+			"step 'Launch Instance' -> output 'instance_id' (instance)",
+		}, flowEdges(f))
+	})
+
+	t.Run("closures", func(t *testing.T) {
+		assert.Equal(t, []string{"output.instance_id", "Launch Instance", "input.size", "Create VPC", "input.cidr", "local.vpc_name"},
+			flowIDs(f.Closure(f.Find("output.instance_id"), stack.FlowUpstream)))
+		assert.Equal(t, []string{"input.cidr", "local.vpc_name", "Create VPC", "Launch Instance", "output.instance_id", "Create Database"},
+			flowIDs(f.Closure(f.Find("input.cidr"), stack.FlowDownstream)))
+		assert.Equal(t, f.Find("Create VPC"), f.Find("step.Create VPC"))
+		assert.Nil(t, f.Find("input.missing"))
+	})
+
+	t.Run("dot", func(t *testing.T) {
+		n := f.Find("secret.db_password")
+		dot := f.DOT(stack.RenderOptions{Title: "web", Focus: n, Highlight: f.Closure(n, stack.FlowDownstream)})
+		assert.Contains(t, dot, "digraph \"web\" {\n")
+		assert.Contains(t, dot, "  n2 [label=\"secret: db_password\", shape=octagon, style=filled, fillcolor=\"#ff8a65\"];\n")
+		assert.Contains(t, dot, "  subgraph cluster_1 {\n    label=\"layer 'compute'\";\n    n5 [label=\"Launch Instance\", shape=box];\n")
+		assert.Contains(t, dot, "    n6 [label=\"Create Database\", shape=box, style=filled, fillcolor=\"#ffd54f\"];\n")
+		assert.Contains(t, dot, "  n4 -> n5 [label=\"vpc\"];\n")
+		assert.Contains(t, dot, "  n4 -> n6 [style=dashed];\n")
+		assert.Contains(t, dot, "  n2 -> n6 [color=\"#f57f17\", penwidth=2];\n")
+	})
+
+	t.Run("mermaid", func(t *testing.T) {
+		n := f.Find("Create VPC")
+		mermaid := f.Mermaid(stack.RenderOptions{Focus: n, Highlight: f.Closure(n, stack.FlowUpstream)})
+		assert.Contains(t, mermaid, "flowchart LR\n")
+		assert.Contains(t, mermaid, "  n0([\"input: cidr\"])\n")
+		assert.Contains(t, mermaid, "  subgraph layer0 [\"layer 'network'\"]\n    n4[\"Create VPC\"]\n  end\n")
+		assert.Contains(t, mermaid, "  n4 -->|\"vpc\"| n5\n")
+		assert.Contains(t, mermaid, "  n4 -.-> n6\n")
+		assert.Contains(t, mermaid, "  class n0,n3 highlight\n")
+		assert.Contains(t, mermaid, "  class n4 focus\n")
+		assert.Contains(t, mermaid, "  linkStyle 0,1,2 stroke:#f57f17,stroke-width:2px\n")
+	})
+}
@@ -0,0 +1,147 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Severity is how serious a diagnostic is
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Codes of all diagnostics reported by Validate
+const (
+	CodeMissingMetadata     = "missing-metadata"
+	CodeInputMissingType    = "input-missing-type"
+	CodeInputInvalidType    = "input-invalid-type"
+	CodeDefaultTypeMismatch = "default-type-mismatch"
+	CodeAllowedTypeMismatch = "allowed-type-mismatch"
+	CodeInputMissingDefault = "input-missing-default"
+	CodeDefaultNotAllowed   = "default-not-allowed"
+	CodeInvalidConstraint   = "invalid-constraint"
+	CodeDefaultConstraint   = "default-constraint"
+	CodeLayerMissingName    = "layer-missing-name"
+	CodeStepMissingFields   = "step-missing-name-or-action"
+	CodeTemplateSyntax      = "template-syntax"
+	CodeUnknownFunction     = "unknown-function"
+	CodeInvalidReference    = "invalid-reference"
+	CodeUndefinedInput      = "undefined-input"
+	CodeUndefinedSecret     = "undefined-secret"
+	CodeUndefinedVariable   = "undefined-variable"
+	CodeForwardReference    = "forward-reference"
+	CodeSelfReference       = "self-reference"
+	CodeMaybeAbsent         = "maybe-absent-variable"
+	CodeInvalidLoop         = "invalid-loop"
+	CodeUnknownDependency   = "unknown-dependency"
+	CodeDependencyCycle     = "dependency-cycle"
+	CodeComponentInput      = "invalid-component-input"
+	CodeUndefinedLocal      = "undefined-local"
+	CodeLocalCycle          = "local-cycle"
+	CodeOutputMissingValue  = "output-missing-value"
+	CodeOutputInvalidType   = "output-invalid-type"
+	CodeOutputTypeMismatch  = "output-type-mismatch"
+	CodeSecretLeak          = "secret-leak"
+)
+
+// Diagnostic is a single problem found in a stack template
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	Pos      Position `json:"position"`
+}
+
+// String formats the diagnostic as "file:line:col: severity: message [code]"
+func (d Diagnostic) String() string {
+	msg := fmt.Sprintf("%s: %s [%s]", d.Severity, d.Message, d.Code)
+	if pos := d.Pos.String(); pos != "" {
+		return pos + ": " + msg
+	}
+	return msg
+}
+
+// Diagnostics is a list of problems found in a stack template
+type Diagnostics []Diagnostic
+
+// HasErrors reports whether any of the diagnostics is an error
+func (d Diagnostics) HasErrors() bool {
+	return d.Count(SeverityError) > 0
+}
+
+// Count returns the number of diagnostics with the given severity
+func (d Diagnostics) Count(severity Severity) int {
+	n := 0
+	for _, diag := range d {
+		if diag.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Err joins all error diagnostics into a single error, or returns nil if there are none
+func (d Diagnostics) Err() error {
+	var errs []error
+	for _, diag := range d {
+		if diag.Severity == SeverityError {
+			errs = append(errs, &PosError{Pos: diag.Pos, Err: errors.New(diag.Message)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Sort orders the diagnostics by file position, then by message
+func (d Diagnostics) Sort() {
+	sort.SliceStable(d, func(i, j int) bool {
+		a, b := d[i].Pos, d[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return d[i].Message < d[j].Message
+	})
+}
+
+func (d *Diagnostics) add(severity Severity, pos Position, code, format string, args ...any) {
+	*d = append(*d, Diagnostic{
+		Severity: severity,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Pos:      pos,
+	})
+}
+
+func (d *Diagnostics) errorf(pos Position, code, format string, args ...any) {
+	d.add(SeverityError, pos, code, format, args...)
+}
+
+func (d *Diagnostics) warnf(pos Position, code, format string, args ...any) {
+	d.add(SeverityWarning, pos, code, format, args...)
+}
@@ -0,0 +1,487 @@
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeKind is how an element of a stack changed between two versions
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "changed"
+	}
+	return fmt.Sprintf("change(%d)", int(k))
+}
+
+func (k ChangeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// Change is a single difference between two versions of a stack
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	// Path of the changed element, like "inputs.region.default" or
+	// "layers[network].steps[Create VPC].params.cidr_block"
+	Path    string `json:"path"`
+	Message string `json:"message"`
+	Old     any    `json:"old,omitempty"`
+	New     any    `json:"new,omitempty"`
+	// Set when the change can break the deployments or users of the stack,
+	// like removing an input they set
+	Breaking bool `json:"breaking,omitempty"`
+}
+
+// String formats the change as "+ message", "- message" or "~ message",
+// marking breaking changes
+func (c Change) String() string {
+	symbol := map[ChangeKind]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeModified: "~"}[c.Kind]
+	if c.Breaking {
+		return symbol + " " + c.Message + " [breaking]"
+	}
+	return symbol + " " + c.Message
+}
+
+// Changes is a list of differences between two versions of a stack
+type Changes []Change
+
+// HasBreaking reports whether any of the changes is breaking
+func (c Changes) HasBreaking() bool {
+	return slices.ContainsFunc(c, func(change Change) bool { return change.Breaking })
+}
+
+// Diff compares two versions of a stack and returns what changed, in the
+// order the elements appear in a stack: metadata, provider, inputs, secrets,
+// locals, components, layers and outputs. Steps are matched by name within
+// their layer or component, and layers by name.
+func Diff(old, new *Stack) Changes {
+	logrus.WithField("stack", new.Name).Trace("Comparing stacks")
+	d := &differ{}
+	d.field("version", "version of the stack", old.Version, new.Version, false)
+	d.field("name", "name of the stack", old.Name, new.Name, false)
+	d.field("display_name", "display name of the stack", old.DisplayName, new.DisplayName, false)
+	d.field("description", "description of the stack", old.Description, new.Description, false)
+	d.field("provider.type", "provider type", old.Provider.Type, new.Provider.Type, true)
+	d.value("provider.properties", func(sub string) string {
+		return "provider property '" + sub + "'"
+	}, old.Provider.Properties, new.Provider.Properties)
+
+	d.inputs("inputs", "", old.Inputs, new.Inputs, true)
+	d.secrets(old.Secrets, new.Secrets)
+	for _, name := range unionKeys(old.Locals, new.Locals) {
+		oldVal, inOld := old.Locals[name]
+		newVal, inNew := new.Locals[name]
+		path := "locals." + name
+		switch {
+		case !inOld:
+			d.add(ChangeAdded, path, nil, newVal, false, "local '%s' added", name)
+		case !inNew:
+			d.add(ChangeRemoved, path, oldVal, nil, false, "local '%s' removed", name)
+		default:
+			d.value(path, func(sub string) string {
+				return describeValue("local '"+name+"'", sub)
+			}, oldVal, newVal)
+		}
+	}
+	d.components(old.Components, new.Components)
+	d.layers(old.Layers, new.Layers)
+	d.outputs(old.Outputs, new.Outputs)
+	return d.changes
+}
+
+// differ collects the changes between two stacks
+type differ struct {
+	changes Changes
+}
+
+func (d *differ) add(kind ChangeKind, path string, old, new any, breaking bool, format string, args ...any) {
+	d.changes = append(d.changes, Change{
+		Kind:     kind,
+		Path:     path,
+		Message:  fmt.Sprintf(format, args...),
+		Old:      old,
+		New:      new,
+		Breaking: breaking,
+	})
+}
+
+// Compares a single field, described by what, like "default of input 'region'"
+func (d *differ) field(path, what string, old, new any, breaking bool) {
+	old, new = deref(old), deref(new)
+	if reflect.DeepEqual(old, new) {
+		return
+	}
+	switch {
+	case isUnset(old):
+		d.add(ChangeAdded, path, nil, new, breaking, "%s set to %s", what, formatValue(new))
+	case isUnset(new):
+		d.add(ChangeRemoved, path, old, nil, breaking, "%s removed", what)
+	default:
+		d.add(ChangeModified, path, old, new, breaking, "%s changed from %s to %s", what, formatValue(old), formatValue(new))
+	}
+}
+
+// Compares two values key by key and item by item. what describes the value
+// at a path below it, or the value itself for an empty path.
+func (d *differ) value(path string, what func(sub string) string, old, new any) {
+	var walk func(sub string, old, new any)
+	walk = func(sub string, old, new any) {
+		oldMap, oldIsMap := old.(map[string]any)
+		newMap, newIsMap := new.(map[string]any)
+		oldList, oldIsList := old.([]any)
+		newList, newIsList := new.([]any)
+		switch {
+		case oldIsMap && newIsMap:
+			for _, key := range unionKeys(oldMap, newMap) {
+				walk(joinPath(sub, key), oldMap[key], newMap[key])
+			}
+		case oldIsList && newIsList && len(oldList) == len(newList):
+			for i := range oldList {
+				walk(sub+"["+strconv.Itoa(i)+"]", oldList[i], newList[i])
+			}
+		default:
+			full := path
+			if sub != "" {
+				full = joinPath(path, sub)
+				if sub[0] == '[' {
+					full = path + sub
+				}
+			}
+			d.field(full, what(sub), old, new, false)
+		}
+	}
+	walk("", old, new)
+}
+
+// Compares the inputs of a stack or component. Changes to the inputs of the
+// stack are breaking when they can fail deployments that worked before.
+func (d *differ) inputs(path, of string, old, new map[string]Input, external bool) {
+	for _, name := range unionKeys(old, new) {
+		oldInput, inOld := old[name]
+		newInput, inNew := new[name]
+		inputPath := path + "." + name
+		required := newInput.Required || newInput.Default == nil
+		switch {
+		case !inOld && newInput.Default == nil:
+			d.add(ChangeAdded, inputPath, nil, nil, external, "required input '%s'%s added without a default", name, of)
+		case !inOld && required:
+			// Required inputs have to be given even if they have a default
+			d.add(ChangeAdded, inputPath, nil, nil, external, "required input '%s'%s added", name, of)
+		case !inOld:
+			d.add(ChangeAdded, inputPath, nil, nil, false, "input '%s'%s added", name, of)
+		case !inNew:
+			d.add(ChangeRemoved, inputPath, nil, nil, external, "input '%s'%s removed", name, of)
+		default:
+			what := func(field string) string { return field + " of input '" + name + "'" + of }
+			d.field(inputPath+".type", what("type"), oldInput.Type, newInput.Type, external)
+			// Deployments that left out the input fail once it's required
+			breaking := external && required && !oldInput.Required && oldInput.Default != nil
+			d.field(inputPath+".default", what("default"), oldInput.Default, newInput.Default, breaking && newInput.Default == nil)
+			if newInput.Required && !oldInput.Required {
+				d.add(ChangeModified, inputPath+".required", false, true, breaking, "input '%s'%s is now required", name, of)
+			} else if oldInput.Required && !newInput.Required {
+				d.add(ChangeModified, inputPath+".required", true, false, false, "input '%s'%s is no longer required", name, of)
+			}
+			d.field(inputPath+".description", what("description"), oldInput.Description, newInput.Description, false)
+			d.field(inputPath+".label", what("label"), oldInput.Label, newInput.Label, false)
+			d.field(inputPath+".allowed", what("allowed values"), allowedValues(oldInput.Allowed), allowedValues(newInput.Allowed),
+				external && disallows(oldInput.Allowed, newInput.Allowed))
+			d.field(inputPath+".pattern", what("pattern"), oldInput.Pattern, newInput.Pattern, false)
+			d.field(inputPath+".min", what("min"), oldInput.Min, newInput.Min, false)
+			d.field(inputPath+".max", what("max"), oldInput.Max, newInput.Max, false)
+			d.field(inputPath+".min_length", what("min_length"), oldInput.MinLength, newInput.MinLength, false)
+			d.field(inputPath+".max_length", what("max_length"), oldInput.MaxLength, newInput.MaxLength, false)
+			d.field(inputPath+".unique", what("unique"), oldInput.Unique, newInput.Unique, false)
+			d.field(inputPath+".messages", what("messages"), oldInput.Messages, newInput.Messages, false)
+		}
+	}
+}
+
+// Compares the secrets of a stack. New secrets are breaking, since they have
+// to be given to deploy the stack.
+func (d *differ) secrets(old, new map[string]Secret) {
+	for _, name := range unionKeys(old, new) {
+		oldSecret, inOld := old[name]
+		newSecret, inNew := new[name]
+		path := "secrets." + name
+		switch {
+		case !inOld:
+			d.add(ChangeAdded, path, nil, nil, true, "secret '%s' added", name)
+		case !inNew:
+			d.add(ChangeRemoved, path, nil, nil, false, "secret '%s' removed", name)
+		default:
+			what := func(field string) string { return field + " of secret '" + name + "'" }
+			d.field(path+".type", what("type"), oldSecret.Type, newSecret.Type, true)
+			d.field(path+".description", what("description"), oldSecret.Description, newSecret.Description, false)
+			d.field(path+".label", what("label"), oldSecret.Label, newSecret.Label, false)
+			d.field(path+".allowed", what("allowed values"), allowedValues(oldSecret.Allowed), allowedValues(newSecret.Allowed),
+				disallows(oldSecret.Allowed, newSecret.Allowed))
+		}
+	}
+}
+
+func (d *differ) components(old, new map[string]Component) {
+	for _, name := range unionKeys(old, new) {
+		oldComp, inOld := old[name]
+		newComp, inNew := new[name]
+		path := "components." + name
+		switch {
+		case !inOld:
+			d.add(ChangeAdded, path, nil, nil, false, "component '%s' added", name)
+		case !inNew:
+			d.add(ChangeRemoved, path, nil, nil, false, "component '%s' removed", name)
+		default:
+			d.field(path+".description", "description of component '"+name+"'", oldComp.Description, newComp.Description, false)
+			d.inputs(path+".inputs", " of component '"+name+"'", oldComp.Inputs, newComp.Inputs, false)
+			d.steps(path+".steps", "component '"+name+"'", oldComp.Steps, newComp.Steps)
+		}
+	}
+}
+
+func (d *differ) layers(old, new []Layer) {
+	oldLayers := make(map[string]*Layer, len(old))
+	for i := range old {
+		oldLayers[old[i].Name] = &old[i]
+	}
+	newLayers := make(map[string]*Layer, len(new))
+	for i := range new {
+		newLayers[new[i].Name] = &new[i]
+	}
+	for i := range old {
+		if _, ok := newLayers[old[i].Name]; !ok {
+			d.add(ChangeRemoved, "layers["+old[i].Name+"]", nil, nil, false, "layer '%s' removed", old[i].Name)
+		}
+	}
+	for i := range new {
+		layer := &new[i]
+		path := "layers[" + layer.Name + "]"
+		oldLayer, ok := oldLayers[layer.Name]
+		if !ok {
+			d.add(ChangeAdded, path, nil, nil, false, "layer '%s' added", layer.Name)
+			continue
+		}
+		d.field(path+".when", "condition of layer '"+layer.Name+"'", oldLayer.When, layer.When, false)
+		d.steps(path+".steps", "layer '"+layer.Name+"'", sourceSteps(oldLayer.Steps), sourceSteps(layer.Steps))
+	}
+}
+
+// Compares the steps of a layer or component, matching them by name
+func (d *differ) steps(path, in string, old, new []Step) {
+	oldSteps := keyedSteps(old)
+	newSteps := keyedSteps(new)
+	for _, key := range stepKeys(old) {
+		if _, ok := newSteps[key]; !ok {
+			d.add(ChangeRemoved, path+"["+key+"]", nil, nil, false, "step '%s' removed from %s", key, in)
+		}
+	}
+	for _, key := range stepKeys(new) {
+		step := newSteps[key]
+		stepPath := path + "[" + key + "]"
+		oldStep, ok := oldSteps[key]
+		if !ok {
+			d.add(ChangeAdded, stepPath, nil, nil, false, "step '%s' added to %s", key, in)
+			continue
+		}
+		of := " of step '" + key + "' in " + in
+		d.field(stepPath+".action", "action"+of, oldStep.Action, step.Action, false)
+		d.value(stepPath+".params", func(sub string) string {
+			return describeValue("params", sub) + of
+		}, oldStep.Params, step.Params)
+		if oldStep.Register != "" && step.Register != "" && oldStep.Register != step.Register {
+			d.add(ChangeModified, stepPath+".register", oldStep.Register, step.Register, false,
+				"variable registered by step '%s' in %s renamed from '%s' to '%s'", key, in, oldStep.Register, step.Register)
+		} else {
+			d.field(stepPath+".register", "registered variable"+of, oldStep.Register, step.Register, false)
+		}
+		d.field(stepPath+".tags", "tags"+of, stringsValue(oldStep.Tags), stringsValue(step.Tags), false)
+		d.field(stepPath+".depends_on", "dependencies"+of, stringsValue(oldStep.DependsOn), stringsValue(step.DependsOn), false)
+		d.field(stepPath+".when", "condition"+of, oldStep.When, step.When, false)
+		d.field(stepPath+".for_each", "for_each"+of, oldStep.ForEach, step.ForEach, false)
+		d.field(stepPath+".count", "count"+of, oldStep.Count, step.Count, false)
+		d.field(stepPath+".use", "component used"+of, oldStep.Use, step.Use, false)
+		d.value(stepPath+".with", func(sub string) string {
+			return describeValue("component input", sub) + of
+		}, anyMap(oldStep.With), anyMap(step.With))
+	}
+}
+
+// Compares the outputs of a stack. Removing an output breaks its users.
+func (d *differ) outputs(old, new map[string]Output) {
+	for _, name := range unionKeys(old, new) {
+		oldOutput, inOld := old[name]
+		newOutput, inNew := new[name]
+		path := "outputs." + name
+		switch {
+		case !inOld:
+			d.add(ChangeAdded, path, nil, nil, false, "output '%s' added", name)
+		case !inNew:
+			d.add(ChangeRemoved, path, nil, nil, true, "output '%s' removed", name)
+		default:
+			what := func(field string) string { return field + " of output '" + name + "'" }
+			d.value(path+".value", func(sub string) string {
+				return describeValue("value", sub) + " of output '" + name + "'"
+			}, oldOutput.Value, newOutput.Value)
+			d.field(path+".type", what("type"), oldOutput.Type, newOutput.Type, true)
+			d.field(path+".sensitive", what("sensitive"), oldOutput.Sensitive, newOutput.Sensitive, false)
+			d.field(path+".description", what("description"), oldOutput.Description, newOutput.Description, false)
+		}
+	}
+}
+
+// Returns the steps as they were defined, with each use of a component in
+// place of the copies of the component's steps
+func sourceSteps(steps []Step) []Step {
+	var source []Step
+	var last *componentUse
+	for _, step := range steps {
+		switch {
+		case step.use == nil:
+			source = append(source, step)
+		case step.use != last:
+			source = append(source, step.use.step)
+		}
+		last = step.use
+	}
+	return source
+}
+
+// Returns the keys steps are matched by, which are their names. Steps with the
+// same name as an earlier step get their occurrence added, like "Create (2)".
+func stepKeys(steps []Step) []string {
+	keys := make([]string, len(steps))
+	seen := make(map[string]int)
+	for i, step := range steps {
+		seen[step.Name]++
+		keys[i] = step.Name
+		if n := seen[step.Name]; n > 1 {
+			keys[i] = fmt.Sprintf("%s (%d)", step.Name, n)
+		}
+	}
+	return keys
+}
+
+func keyedSteps(steps []Step) map[string]*Step {
+	keyed := make(map[string]*Step, len(steps))
+	for i, key := range stepKeys(steps) {
+		keyed[key] = &steps[i]
+	}
+	return keyed
+}
+
+// Describes a value, or the part of it at a path
+func describeValue(what, sub string) string {
+	if sub == "" {
+		return what
+	}
+	if what == "params" {
+		return "param '" + sub + "'"
+	}
+	return what + " '" + sub + "'"
+}
+
+// Returns the keys of both maps in order
+func unionKeys[V any](a, b map[string]V) []string {
+	keys := sortedKeys(a)
+	for _, key := range sortedKeys(b) {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Returns the values allowed for an input or secret
+func allowedValues(allowed []AllowedValue) []any {
+	if len(allowed) == 0 {
+		return nil
+	}
+	values := make([]any, len(allowed))
+	for i, a := range allowed {
+		values[i] = a.Value
+	}
+	return values
+}
+
+// Reports whether a value allowed before isn't allowed anymore
+func disallows(old, new []AllowedValue) bool {
+	if len(new) == 0 {
+		return false
+	}
+	newValues := allowedValues(new)
+	for _, a := range old {
+		if !slices.ContainsFunc(newValues, func(v any) bool { return reflect.DeepEqual(v, a.Value) }) {
+			return true
+		}
+	}
+	return len(old) == 0
+}
+
+func stringsValue(s []string) any {
+	if len(s) == 0 {
+		return nil
+	}
+	return s
+}
+
+func anyMap(m map[string]any) any {
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+// Dereferences pointers, like the optional constraints of inputs
+func deref(val any) any {
+	v := reflect.ValueOf(val)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		return v.Elem().Interface()
+	}
+	return val
+}
+
+// Reports whether a field is unset. Unlike isEmpty, zero numbers are set.
+func isUnset(val any) bool {
+	if val == nil {
+		return true
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	}
+	return false
+}
+
+// Formats a value for a message. Strings are quoted like names.
+func formatValue(val any) string {
+	if s, ok := val.(string); ok {
+		return "'" + s + "'"
+	}
+	if data, err := json.Marshal(val); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(val)
+}
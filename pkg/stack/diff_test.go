@@ -0,0 +1,216 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func diffStacks(t *testing.T, oldData, newData string) stack.Changes {
+	t.Helper()
+	oldStack, err := stack.ParseFile("old.yml", []byte(oldData))
+	require.NoError(t, err)
+	newStack, err := stack.ParseFile("new.yml", []byte(newData))
+	require.NoError(t, err)
+	return stack.Diff(oldStack, newStack)
+}
+
+func changeStrings(changes stack.Changes) []string {
+	s := make([]string, len(changes))
+	for i, c := range changes {
+		s[i] = c.String()
+	}
+	return s
+}
+
+func TestDiff(t *testing.T) {
+	t.Run("no changes", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: main
+    steps:
+      - name: Create
+        aws.thing:
+          size: 1
+`
+		assert.Empty(t, diffStacks(t, yamlData, yamlData))
+	})
+
+	t.Run("inputs", func(t *testing.T) {
+		changes := diffStacks(t, `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    default: us-east-1
+  legacy:
+    type: string
+    default: x
+  size:
+    type: number
+    default: 1
+layers: []
+`, `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    default: eu-west-1
+  size:
+    type: number
+    required: true
+  zone:
+    type: string
+    required: true
+  env:
+    type: string
+    default: dev
+  tier:
+    type: string
+    required: true
+    default: web
+layers: []
+`)
+		assert.Equal(t, []string{
+			"+ input 'env' added",
+			"- input 'legacy' removed [breaking]",
+			"~ default of input 'region' changed from 'us-east-1' to 'eu-west-1'",
+			"- default of input 'size' removed [breaking]",
+			"~ input 'size' is now required [breaking]",
+			"+ required input 'tier' added [breaking]",
+			"+ required input 'zone' added without a default [breaking]",
+		}, changeStrings(changes))
+		assert.Equal(t, "inputs.region.default", changes[2].Path)
+		assert.Equal(t, "us-east-1", changes[2].Old)
+		assert.Equal(t, "eu-west-1", changes[2].New)
+		assert.True(t, changes.HasBreaking())
+	})
+
+	t.Run("steps", func(t *testing.T) {
+		changes := diffStacks(t, `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          cidr_block: 10.0.0.0/16
+          tags:
+            env: dev
+        register: vpc
+      - name: Old Step
+        aws.thing: {}
+outputs:
+  vpc_id:
+    value: "{{ $.vpc.id }}"
+  old:
+    value: x
+`, `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          cidr_block: 10.1.0.0/16
+          tags:
+            env: dev
+            team: infra
+        register: main_vpc
+      - name: Create Subnet
+        aws.subnet:
+          vpc_id: "{{ $.main_vpc.id }}"
+  - name: compute
+    steps: []
+outputs:
+  vpc_id:
+    value: "{{ $.main_vpc.id }}"
+`)
+		assert.Equal(t, []string{
+			"- step 'Old Step' removed from layer 'network'",
+			"~ param 'cidr_block' of step 'Create VPC' in layer 'network' changed from '10.0.0.0/16' to '10.1.0.0/16'",
+			"+ param 'tags.team' of step 'Create VPC' in layer 'network' set to 'infra'",
+			"~ variable registered by step 'Create VPC' in layer 'network' renamed from 'vpc' to 'main_vpc'",
+			"+ step 'Create Subnet' added to layer 'network'",
+			"+ layer 'compute' added",
+			"- output 'old' removed [breaking]",
+			"~ value of output 'vpc_id' changed from '{{ $.vpc.id }}' to '{{ $.main_vpc.id }}'",
+		}, changeStrings(changes))
+		assert.Equal(t, "layers[network].steps[Create VPC].params.cidr_block", changes[1].Path)
+	})
+
+	t.Run("components", func(t *testing.T) {
+		changes := diffStacks(t, `
+version: "1"
+name: test
+provider:
+  type: aws
+components:
+  network:
+    inputs:
+      cidr:
+        type: string
+    steps:
+      - name: VPC
+        aws.vpc:
+          cidr_block: "{{ $.input.cidr }}"
+layers:
+  - name: main
+    steps:
+      - name: Network
+        use: network
+        with:
+          cidr: 10.0.0.0/16
+`, `
+version: "1"
+name: test
+provider:
+  type: aws
+components:
+  network:
+    inputs:
+      cidr:
+        type: string
+      name:
+        type: string
+    steps:
+      - name: VPC
+        aws.vpc:
+          cidr_block: "{{ $.input.cidr }}"
+          name: "{{ $.input.name }}"
+layers:
+  - name: main
+    steps:
+      - name: Network
+        use: network
+        with:
+          cidr: 10.1.0.0/16
+          name: main
+`)
+		assert.Equal(t, []string{
+			"+ required input 'name' of component 'network' added without a default",
+			"+ param 'name' of step 'VPC' in component 'network' set to '{{ $.input.name }}'",
+			"~ component input 'cidr' of step 'Network' in layer 'main' changed from '10.0.0.0/16' to '10.1.0.0/16'",
+			"+ component input 'name' of step 'Network' in layer 'main' set to 'main'",
+		}, changeStrings(changes))
+		assert.False(t, changes.HasBreaking())
+	})
+}
@@ -0,0 +1,335 @@
+package stack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+
+	"github.com/sirupsen/logrus"
+)
+
+var ErrMissingDocsMarkers = errors.New("missing docs markers")
+
+// Markers of the section of a README that UpdateDocsSection replaces
+const (
+	DocsBeginMarker = "<!-- BEGIN GROUNDCTL DOCS -->"
+	DocsEndMarker   = "<!-- END GROUNDCTL DOCS -->"
+)
+
+// StackDocs is the reference documentation of a stack, as given to the docs
+// templates. Inputs, secrets and outputs are ordered by name.
+type StackDocs struct {
+	// The display name of the stack, or its name
+	Title       string
+	Name        string
+	Version     string
+	Description string
+	Provider    Provider
+	Inputs      []InputDocs
+	Secrets     []SecretDocs
+	Layers      []LayerDocs
+	Outputs     []OutputDocs
+}
+
+type InputDocs struct {
+	Name string
+	Input
+	// Set when the input has to be given, because it's required or has no default
+	Required bool
+}
+
+type SecretDocs struct {
+	Name string
+	Secret
+}
+
+type LayerDocs struct {
+	Name string
+	When string
+	// The steps as they are defined, with steps that use a component in place
+	// of the component's steps
+	Steps []Step
+}
+
+type OutputDocs struct {
+	Name string
+	Output
+}
+
+// Docs collects the reference documentation of the stack
+func (s *Stack) Docs() StackDocs {
+	docs := StackDocs{
+		Title:       s.DisplayName,
+		Name:        s.Name,
+		Version:     s.Version,
+		Description: s.Description,
+		Provider:    s.Provider,
+	}
+	if docs.Title == "" {
+		docs.Title = s.Name
+	}
+	for _, name := range sortedKeys(s.Inputs) {
+		input := s.Inputs[name]
+		docs.Inputs = append(docs.Inputs, InputDocs{Name: name, Input: input, Required: input.Required || input.Default == nil})
+	}
+	for _, name := range sortedKeys(s.Secrets) {
+		docs.Secrets = append(docs.Secrets, SecretDocs{Name: name, Secret: s.Secrets[name]})
+	}
+	for _, layer := range s.Layers {
+		docs.Layers = append(docs.Layers, LayerDocs{Name: layer.Name, When: layer.When, Steps: sourceSteps(layer.Steps)})
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		docs.Outputs = append(docs.Outputs, OutputDocs{Name: name, Output: s.Outputs[name]})
+	}
+	return docs
+}
+
+// Functions available to docs templates
+var docsFuncs = map[string]any{
+	// Formats a value as inline code, like `us-east-1`
+	"code": func(val any) string {
+		return markdownCode(docsValue(val))
+	},
+	// Formats a value as inline code for a Markdown table cell, escaping "|"
+	// and putting each line in its own code span
+	"codeCell": markdownCodeCell,
+	// Formats a value as a plain string or as JSON
+	"value": docsValue,
+	// Escapes text for a Markdown table cell
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>").Replace(strings.TrimSpace(s))
+	},
+	"join": strings.Join,
+	// Returns the values allowed for an input or secret
+	"allowedValues": allowedValues,
+}
+
+// Formats a value for the docs. Strings are shown as they are, unless they're
+// empty or start or end with spaces, and other values as JSON.
+func docsValue(val any) string {
+	if s, ok := val.(string); ok && s != "" && strings.TrimSpace(s) == s {
+		return s
+	}
+	if data, err := json.Marshal(val); err == nil {
+		return string(data)
+	}
+	return fmt.Sprint(val)
+}
+
+// Wraps text in backticks, using more of them if the text has some
+func markdownCode(s string) string {
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
+// Formats a value as inline code that can be put in a Markdown table cell.
+// Table cells can't span lines, so lines are separated by <br>.
+func markdownCodeCell(val any) string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(docsValue(val), "\r\n", "\n"), "\n") {
+		if line != "" {
+			lines = append(lines, strings.ReplaceAll(markdownCode(line), "|", `\|`))
+		}
+	}
+	return strings.Join(lines, "<br>")
+}
+
+// DefaultDocsTemplate renders the docs as Markdown
+const DefaultDocsTemplate = `# {{ .Title }}
+{{ with .Description }}
+{{ . }}
+{{ end }}
+| Stack | Version | Provider |
+| --- | --- | --- |
+| {{ codeCell .Name }} | {{ codeCell .Version }} | {{ codeCell .Provider.Type }} |
+{{- with .Provider.Properties }}
+
+### Provider properties
+
+| Property | Value |
+| --- | --- |
+{{- range $name, $value := . }}
+| {{ codeCell $name }} | {{ codeCell $value }} |
+{{- end }}
+{{- end }}
+{{- with .Inputs }}
+
+## Inputs
+
+| Name | Type | Required | Default | Allowed values | Description |
+| --- | --- | --- | --- | --- | --- |
+{{- range . }}
+| {{ codeCell .Name }} | {{ codeCell .Type }} | {{ if .Required }}yes{{ else }}no{{ end }} | {{ if ne .Default nil }}{{ codeCell .Default }}{{ end }} | {{ range $i, $v := allowedValues .Allowed }}{{ if $i }}, {{ end }}{{ codeCell $v }}{{ end }} | {{ with .Label }}**{{ cell . }}** {{ end }}{{ cell .Description }} |
+{{- end }}
+{{- end }}
+{{- with .Secrets }}
+
+## Secrets
+
+| Name | Type | Description |
+| --- | --- | --- |
+{{- range . }}
+| {{ codeCell .Name }} | {{ codeCell .Type }} | {{ with .Label }}**{{ cell . }}** {{ end }}{{ cell .Description }} |
+{{- end }}
+{{- end }}
+{{- with .Layers }}
+
+## Layers
+{{- range . }}
+
+### {{ .Name }}
+{{ with .When }}
+Runs when {{ code . }}.
+{{ end }}
+| Step | Action | Registers | Depends on |
+| --- | --- | --- | --- |
+{{- range .Steps }}
+| {{ cell .Name }} | {{ if .Use }}component {{ codeCell .Use }}{{ else }}{{ codeCell .Action }}{{ end }} | {{ with .Register }}{{ codeCell . }}{{ end }} | {{ range $i, $d := .DependsOn }}{{ if $i }}, {{ end }}{{ cell $d }}{{ end }} |
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Outputs }}
+
+## Outputs
+
+| Name | Type | Sensitive | Description |
+| --- | --- | --- | --- |
+{{- range . }}
+| {{ codeCell .Name }} | {{ with .Type }}{{ codeCell . }}{{ end }} | {{ if .Sensitive }}yes{{ else }}no{{ end }} | {{ cell .Description }} |
+{{- end }}
+{{- end }}
+`
+
+// DefaultHTMLDocsTemplate renders the docs as an HTML page
+const DefaultHTMLDocsTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+{{- with .Description }}
+<p>{{ . }}</p>
+{{- end }}
+<table>
+<tr><th>Stack</th><th>Version</th><th>Provider</th></tr>
+<tr><td><code>{{ .Name }}</code></td><td><code>{{ .Version }}</code></td><td><code>{{ .Provider.Type }}</code></td></tr>
+</table>
+{{- with .Provider.Properties }}
+<h3>Provider properties</h3>
+<table>
+<tr><th>Property</th><th>Value</th></tr>
+{{- range $name, $value := . }}
+<tr><td><code>{{ $name }}</code></td><td><code>{{ value $value }}</code></td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .Inputs }}
+<h2>Inputs</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Required</th><th>Default</th><th>Allowed values</th><th>Description</th></tr>
+{{- range . }}
+<tr><td><code>{{ .Name }}</code></td><td><code>{{ .Type }}</code></td><td>{{ if .Required }}yes{{ else }}no{{ end }}</td><td>{{ if ne .Default nil }}<code>{{ value .Default }}</code>{{ end }}</td><td>{{ range $i, $v := allowedValues .Allowed }}{{ if $i }}, {{ end }}<code>{{ value $v }}</code>{{ end }}</td><td>{{ with .Label }}<strong>{{ . }}</strong> {{ end }}{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .Secrets }}
+<h2>Secrets</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Description</th></tr>
+{{- range . }}
+<tr><td><code>{{ .Name }}</code></td><td><code>{{ .Type }}</code></td><td>{{ with .Label }}<strong>{{ . }}</strong> {{ end }}{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- with .Layers }}
+<h2>Layers</h2>
+{{- range . }}
+<h3>{{ .Name }}</h3>
+{{- with .When }}
+<p>Runs when <code>{{ . }}</code>.</p>
+{{- end }}
+<table>
+<tr><th>Step</th><th>Action</th><th>Registers</th><th>Depends on</th></tr>
+{{- range .Steps }}
+<tr><td>{{ .Name }}</td><td>{{ if .Use }}component <code>{{ .Use }}</code>{{ else }}<code>{{ .Action }}</code>{{ end }}</td><td>{{ with .Register }}<code>{{ . }}</code>{{ end }}</td><td>{{ join .DependsOn ", " }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+{{- end }}
+{{- with .Outputs }}
+<h2>Outputs</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Sensitive</th><th>Description</th></tr>
+{{- range . }}
+<tr><td><code>{{ .Name }}</code></td><td>{{ with .Type }}<code>{{ . }}</code>{{ end }}</td><td>{{ if .Sensitive }}yes{{ else }}no{{ end }}</td><td>{{ .Description }}</td></tr>
+{{- end }}
+</table>
+{{- end }}
+</body>
+</html>
+`
+
+// RenderDocs renders the stack's docs with a Go template, or with
+// DefaultDocsTemplate if the template is empty. The template is given the
+// StackDocs of the stack.
+func (s *Stack) RenderDocs(tmpl string) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultDocsTemplate
+	}
+	t, err := template.New("docs").Funcs(docsFuncs).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid docs template: %w", err)
+	}
+	logrus.WithField("stack", s.Name).Trace("Rendering docs")
+	var b strings.Builder
+	if err := t.Execute(&b, s.Docs()); err != nil {
+		return "", fmt.Errorf("failed to render docs: %w", err)
+	}
+	return b.String(), nil
+}
+
+// RenderHTMLDocs renders the stack's docs like RenderDocs, but escapes the
+// values for HTML. An empty template renders DefaultHTMLDocsTemplate.
+func (s *Stack) RenderHTMLDocs(tmpl string) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultHTMLDocsTemplate
+	}
+	t, err := htmltemplate.New("docs").Funcs(docsFuncs).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid docs template: %w", err)
+	}
+	logrus.WithField("stack", s.Name).Trace("Rendering HTML docs")
+	var b strings.Builder
+	if err := t.Execute(&b, s.Docs()); err != nil {
+		return "", fmt.Errorf("failed to render docs: %w", err)
+	}
+	return b.String(), nil
+}
+
+// UpdateDocsSection replaces the text between DocsBeginMarker and
+// DocsEndMarker in a document, like a README, with the docs. The markers are
+// kept, so that the section can be updated again.
+func UpdateDocsSection(doc, docs string) (string, error) {
+	begin := strings.Index(doc, DocsBeginMarker)
+	if begin < 0 {
+		return "", fmt.Errorf("%w: no %s", ErrMissingDocsMarkers, DocsBeginMarker)
+	}
+	contentStart := begin + len(DocsBeginMarker)
+	end := strings.Index(doc[contentStart:], DocsEndMarker)
+	if end < 0 {
+		return "", fmt.Errorf("%w: no %s after %s", ErrMissingDocsMarkers, DocsEndMarker, DocsBeginMarker)
+	}
+	end += contentStart
+	return doc[:contentStart] + "\n" + strings.TrimSpace(docs) + "\n" + doc[end:], nil
+}
@@ -0,0 +1,141 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const docsStack = `
+version: "1.2"
+name: web
+display_name: Web App
+description: Runs the web app.
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    label: Region
+    description: Where to deploy
+    default: us-east-1
+    allowed:
+      - label: US
+        value: us-east-1
+      - label: EU
+        value: eu-west-1
+  size:
+    type: number
+    required: true
+    description: Instances <b>per</b> zone
+secrets:
+  db_password:
+    type: string
+    description: Password | of the database
+components:
+  network:
+    steps:
+      - name: VPC
+        aws.vpc: {}
+        register: vpc
+layers:
+  - name: network
+    when: "{{ $.input.region }}"
+    steps:
+      - name: Network
+        use: network
+        register: net
+      - name: Instance
+        aws.instance: {}
+        depends_on: [Network/VPC]
+outputs:
+  vpc_id:
+    value: "{{ $.net.vpc.id }}"
+    type: string
+    description: ID of the VPC
+`
+
+func TestRenderDocs(t *testing.T) {
+	s, err := stack.ParseFile("test.yml", []byte(docsStack))
+	require.NoError(t, err)
+
+	t.Run("markdown", func(t *testing.T) {
+		docs, err := s.RenderDocs("")
+		require.NoError(t, err)
+		assert.Contains(t, docs, "# Web App\n\nRuns the web app.\n")
+		assert.Contains(t, docs, "| `web` | `1.2` | `aws` |\n")
+		assert.Contains(t, docs, "| `region` | `string` | no | `us-east-1` | `us-east-1`, `eu-west-1` | **Region** Where to deploy |\n")
+		assert.Contains(t, docs, "| `size` | `number` | yes |  |  | Instances <b>per</b> zone |\n")
+		assert.Contains(t, docs, "| `db_password` | `string` | Password \\| of the database |\n")
+		assert.Contains(t, docs, "### network\n\nRuns when `{{ $.input.region }}`.\n")
+		assert.Contains(t, docs, "| Network | component `network` | `net` |  |\n| Instance | `aws.instance` |  | Network/VPC |\n")
+		assert.Contains(t, docs, "| `vpc_id` | `string` | no | ID of the VPC |\n")
+	})
+
+	t.Run("values in table cells", func(t *testing.T) {
+		s, err := stack.ParseFile("test.yml", []byte(`
+version: "1"
+name: web
+provider:
+  type: aws
+  properties:
+    filter: "a|b"
+inputs:
+  separator:
+    type: string
+    default: "|"
+  script:
+    type: string
+    default: |-
+      echo a
+      echo b
+layers: []
+`))
+		require.NoError(t, err)
+		docs, err := s.RenderDocs("")
+		require.NoError(t, err)
+		assert.Contains(t, docs, "| `filter` | `a\\|b` |\n")
+		assert.Contains(t, docs, "| `separator` | `string` | no | `\\|` |  |  |\n")
+		assert.Contains(t, docs, "| `script` | `string` | no | `echo a`<br>`echo b` |  |  |\n")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		docs, err := s.RenderHTMLDocs("")
+		require.NoError(t, err)
+		assert.Contains(t, docs, "<h1>Web App</h1>")
+		assert.Contains(t, docs, "<td>Instances &lt;b&gt;per&lt;/b&gt; zone</td>")
+		assert.Contains(t, docs, "<td><code>us-east-1</code>, <code>eu-west-1</code></td>")
+	})
+
+	t.Run("custom template", func(t *testing.T) {
+		docs, err := s.RenderDocs(`{{ .Title }}:{{ range .Inputs }} {{ .Name }}={{ code .Default }}{{ end }}`)
+		require.NoError(t, err)
+		assert.Equal(t, "Web App: region=`us-east-1` size=`null`", docs)
+
+		_, err = s.RenderDocs(`{{ .Missing`)
+		assert.ErrorContains(t, err, "invalid docs template")
+	})
+}
+
+func TestUpdateDocsSection(t *testing.T) {
+	t.Run("replaces the marked section", func(t *testing.T) {
+		readme := "# Web\n\n<!-- BEGIN GROUNDCTL DOCS -->\nold docs\n<!-- END GROUNDCTL DOCS -->\n\nMore\n"
+		updated, err := stack.UpdateDocsSection(readme, "new docs\n")
+		require.NoError(t, err)
+		assert.Equal(t, "# Web\n\n<!-- BEGIN GROUNDCTL DOCS -->\nnew docs\n<!-- END GROUNDCTL DOCS -->\n\nMore\n", updated)
+
+		again, err := stack.UpdateDocsSection(updated, "new docs\n")
+		require.NoError(t, err)
+		assert.Equal(t, updated, again)
+	})
+
+	t.Run("missing markers", func(t *testing.T) {
+		_, err := stack.UpdateDocsSection("# Web\n", "docs")
+		assert.ErrorIs(t, err, stack.ErrMissingDocsMarkers)
+
+		_, err = stack.UpdateDocsSection("<!-- END GROUNDCTL DOCS -->\n<!-- BEGIN GROUNDCTL DOCS -->\n", "docs")
+		assert.ErrorIs(t, err, stack.ErrMissingDocsMarkers)
+	})
+}
@@ -0,0 +1,245 @@
+package stack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// The canonical order of the keys of each element of a stack. Keys that aren't
+// listed keep their order after the listed ones.
+var (
+	stackKeyOrder = []string{
+		"version", "name", "display_name", "description", "include", "imports",
+		"provider", "inputs", "secrets", "locals", "components", "layers", "outputs",
+	}
+	providerKeyOrder = []string{"type", "properties"}
+	inputKeyOrder    = []string{
+		"type", "label", "description", "required", "default", "allowed",
+		"pattern", "min", "max", "min_length", "max_length", "unique", "messages",
+	}
+	secretKeyOrder    = []string{"type", "label", "description", "allowed"}
+	allowedKeyOrder   = []string{"label", "value"}
+	outputKeyOrder    = []string{"description", "type", "sensitive", "value"}
+	componentKeyOrder = []string{"description", "inputs", "steps"}
+	layerKeyOrder     = []string{"name", "when", "steps"}
+	// The action of a step is the one key that isn't reserved, and its value
+	// holds the action's parameters
+	stepKeyOrder = []string{
+		"name", "use", "with", "action", "register", "tags",
+		"depends_on", "when", "for_each", "count",
+	}
+)
+
+// Format formats a stack template file in the canonical style: the keys of
+// every stack element are put in a fixed order, strings are only quoted where
+// needed, collections are indented by two spaces and the top level collection
+// sections, like inputs and layers, are separated by a blank line. Comments
+// are kept.
+func Format(data []byte) ([]byte, error) {
+	return FormatFile("", data)
+}
+
+// FormatFile formats a stack template file like Format, adding the filename
+// to errors
+func FormatFile(filename string, data []byte) ([]byte, error) {
+	logrus.Tracef("Formatting stack of %d bytes", len(data))
+	var buf bytes.Buffer
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fileError(filename, err)
+		}
+		if len(doc.Content) > 0 {
+			root := doc.Content[0]
+			// Comments at the top of the file stay there instead of moving
+			// with the first key
+			if root.Kind == yaml.MappingNode && len(root.Content) > 0 && root.Content[0].HeadComment != "" {
+				doc.HeadComment = strings.TrimSpace(doc.HeadComment + "\n\n" + root.Content[0].HeadComment)
+				root.Content[0].HeadComment = ""
+			}
+			formatStack(root)
+		}
+		normalizeQuotes(&doc)
+		if err := enc.Encode(&doc); err != nil {
+			return nil, fileError(filename, err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, fileError(filename, err)
+	}
+	return separateSections(buf.Bytes()), nil
+}
+
+// Orders the keys of the stack document and every element below it
+func formatStack(root *yaml.Node) {
+	sortKeys(root, stackKeyOrder)
+	if _, n := mappingEntry(root, "provider"); n != nil {
+		sortKeys(n, providerKeyOrder)
+	}
+	if _, n := mappingEntry(root, "inputs"); n != nil {
+		formatInputs(n)
+	}
+	forEachValue(root, "secrets", func(secret *yaml.Node) {
+		sortKeys(secret, secretKeyOrder)
+		forEachItem(secret, "allowed", func(item *yaml.Node) {
+			sortKeys(item, allowedKeyOrder)
+		})
+	})
+	forEachValue(root, "outputs", func(output *yaml.Node) {
+		sortKeys(output, outputKeyOrder)
+	})
+	forEachValue(root, "components", func(comp *yaml.Node) {
+		sortKeys(comp, componentKeyOrder)
+		if _, n := mappingEntry(comp, "inputs"); n != nil {
+			formatInputs(n)
+		}
+		forEachItem(comp, "steps", formatStep)
+	})
+	forEachItem(root, "layers", func(layer *yaml.Node) {
+		sortKeys(layer, layerKeyOrder)
+		forEachItem(layer, "steps", formatStep)
+	})
+}
+
+func formatInputs(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 1; i < len(n.Content); i += 2 {
+		input := n.Content[i]
+		sortKeys(input, inputKeyOrder)
+		forEachItem(input, "allowed", func(item *yaml.Node) {
+			sortKeys(item, allowedKeyOrder)
+		})
+	}
+}
+
+func formatStep(step *yaml.Node) {
+	sortKeysBy(step, stepKeyOrder, func(key string) string {
+		if isReservedStepKey(key) {
+			return key
+		}
+		return "action"
+	})
+}
+
+// Calls fn with every value of the mapping under key
+func forEachValue(n *yaml.Node, key string, fn func(*yaml.Node)) {
+	if _, m := mappingEntry(n, key); m != nil && m.Kind == yaml.MappingNode {
+		for i := 1; i < len(m.Content); i += 2 {
+			fn(m.Content[i])
+		}
+	}
+}
+
+// Calls fn with every item of the sequence under key
+func forEachItem(n *yaml.Node, key string, fn func(*yaml.Node)) {
+	if _, seq := mappingEntry(n, key); seq != nil && seq.Kind == yaml.SequenceNode {
+		for _, item := range seq.Content {
+			fn(item)
+		}
+	}
+}
+
+// Puts the entries of a mapping node in the given key order
+func sortKeys(n *yaml.Node, order []string) {
+	sortKeysBy(n, order, func(key string) string { return key })
+}
+
+// Puts the entries of a mapping node in the given order of the keys' kinds.
+// Entries of the same or of unknown kinds keep their order.
+func sortKeysBy(n *yaml.Node, order []string, kind func(key string) string) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return
+	}
+	type entry struct{ key, val *yaml.Node }
+	entries := make([]entry, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		entries = append(entries, entry{n.Content[i], n.Content[i+1]})
+	}
+	rank := func(e entry) int {
+		if i := slices.Index(order, kind(e.key.Value)); i >= 0 {
+			return i
+		}
+		return len(order)
+	}
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		return rank(a) - rank(b)
+	})
+	for i, e := range entries {
+		n.Content[2*i], n.Content[2*i+1] = e.key, e.val
+	}
+}
+
+// Drops the quotes of single line strings that don't need them, and double
+// quotes the ones that do
+func normalizeQuotes(n *yaml.Node) {
+	quoted := yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+	if n.Kind == yaml.ScalarNode && n.Style&quoted != 0 && n.Style&yaml.TaggedStyle == 0 && !strings.Contains(n.Value, "\n") {
+		n.Style &^= quoted
+		if needsQuotes(n) {
+			n.Style |= yaml.DoubleQuotedStyle
+		}
+	}
+	for _, c := range n.Content {
+		normalizeQuotes(c)
+	}
+}
+
+// Strings that YAML 1.1 reads as booleans, which other tools may still do
+var yaml11Bools = []string{"y", "yes", "n", "no", "on", "off"}
+
+// Reports whether a string can't be written without quotes
+func needsQuotes(n *yaml.Node) bool {
+	if slices.Contains(yaml11Bools, strings.ToLower(n.Value)) {
+		return true
+	}
+	out, err := yaml.Marshal(&yaml.Node{Kind: yaml.ScalarNode, Tag: n.Tag, Value: n.Value})
+	return err != nil || len(out) == 0 || out[0] == '\'' || out[0] == '"'
+}
+
+// Top level keys of the collection sections of a stack
+var sectionKeys = []string{"provider", "inputs", "secrets", "locals", "components", "layers", "outputs"}
+
+// Puts a blank line, along with the comments above it, before every collection
+// section and the key after it. Scalar keys like the version and name are
+// kept together.
+func separateSections(data []byte) []byte {
+	lines := strings.SplitAfter(string(data), "\n")
+	var out []string
+	afterSection := false
+	for i, line := range lines {
+		if isTopLevelKey(line) {
+			key, _, _ := strings.Cut(line, ":")
+			section := slices.Contains(sectionKeys, strings.TrimSpace(key))
+			// Move before the comments that belong to the key
+			start := len(out)
+			for start > 0 && strings.HasPrefix(out[start-1], "#") {
+				start--
+			}
+			if (section || afterSection) && start > 0 && strings.TrimSpace(out[start-1]) != "" && !strings.HasPrefix(out[start-1], "---") {
+				out = slices.Insert(out, start, "\n")
+			}
+			afterSection = section
+		}
+		out = append(out, lines[i])
+	}
+	return []byte(strings.Join(out, ""))
+}
+
+func isTopLevelKey(line string) bool {
+	return line != "" && !strings.ContainsRune(" \t\n#-", rune(line[0])) && !strings.HasPrefix(line, "...")
+}
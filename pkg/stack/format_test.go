@@ -0,0 +1,118 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	t.Run("canonical order and style", func(t *testing.T) {
+		yamlData := `# Network stack
+layers:
+- steps:
+  - tags: [network]
+    register: vpc
+    aws.vpc:
+        cidr_block: '10.0.0.0/16'
+        enable_dns: "yes"
+    # The main VPC
+    name: "Create VPC"
+  name: networking
+provider: {type: aws}
+name: "network"
+x-owner: infra
+version: "1.0"
+description: The network
+inputs:
+  cidr:
+    default: 10.0.0.0/16
+    type: string # a CIDR block
+`
+		expected := `# Network stack
+
+version: "1.0"
+name: network
+description: The network
+
+provider: {type: aws}
+
+inputs:
+  cidr:
+    type: string # a CIDR block
+    default: 10.0.0.0/16
+
+layers:
+  - name: networking
+    steps:
+      - # The main VPC
+        name: Create VPC
+        aws.vpc:
+          cidr_block: 10.0.0.0/16
+          enable_dns: "yes"
+        register: vpc
+        tags: [network]
+
+x-owner: infra
+`
+		formatted, err := stack.Format([]byte(yamlData))
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(formatted))
+
+		again, err := stack.Format(formatted)
+		require.NoError(t, err)
+		assert.Equal(t, string(formatted), string(again), "formatting is idempotent")
+	})
+
+	t.Run("formatted stack is unchanged", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: main
+    steps:
+      - count: 2
+        when: "{{ $.input.enabled }}"
+        name: "Instance {{ $.each.index }}"
+        aws.instance:
+          ami: "ami-123"
+components:
+  web:
+    steps:
+      - name: Server
+        aws.instance: {}
+outputs:
+  id:
+    value: "{{ $.input.id }}"
+    description: The ID
+`
+		formatted, err := stack.Format([]byte(yamlData))
+		require.NoError(t, err)
+		original, err := stack.Parse([]byte(yamlData))
+		require.NoError(t, err)
+		reformatted, err := stack.Parse(formatted)
+		require.NoError(t, err)
+		assert.Equal(t, original.Layers[0].Steps[0].Params, reformatted.Layers[0].Steps[0].Params)
+		assert.Equal(t, original.Layers[0].Steps[0].When, reformatted.Layers[0].Steps[0].When)
+		assert.Equal(t, original.Outputs["id"].Value, reformatted.Outputs["id"].Value)
+		assert.Contains(t, string(formatted), `      - name: Instance {{ $.each.index }}
+        aws.instance:
+          ami: ami-123
+        when: "{{ $.input.enabled }}"
+        count: 2
+`)
+		assert.Contains(t, string(formatted), `  id:
+    description: The ID
+    value: "{{ $.input.id }}"
+`)
+	})
+
+	t.Run("invalid yaml", func(t *testing.T) {
+		_, err := stack.FormatFile("test.yml", []byte("name: [unclosed"))
+		assert.ErrorContains(t, err, "test.yml: ")
+	})
+}
@@ -0,0 +1,231 @@
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// The functions available to stack templates, on top of the text/template builtins
+var templateFuncs = template.FuncMap{
+	"default":    defaultValue,
+	"coalesce":   coalesce,
+	"required":   required,
+	"upper":      func(s any) string { return strings.ToUpper(toString(s)) },
+	"lower":      func(s any) string { return strings.ToLower(toString(s)) },
+	"trim":       func(s any) string { return strings.TrimSpace(toString(s)) },
+	"join":       join,
+	"split":      split,
+	"replace":    replace,
+	"toJson":     toJSON,
+	"fromJson":   fromJSON,
+	"b64enc":     func(s any) string { return base64.StdEncoding.EncodeToString([]byte(toString(s))) },
+	"b64dec":     b64dec,
+	"sha256":     sha256Sum,
+	"cidrsubnet": cidrSubnet,
+	"cidrhost":   cidrHost,
+}
+
+// Names of the functions built into text/template
+var builtinFuncs = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or",
+	"print", "printf", "println", "urlquery", "eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// Reports whether name is a function that can be used in stack templates
+func isTemplateFunc(name string) bool {
+	_, ok := templateFuncs[name]
+	return ok || slices.Contains(builtinFuncs, name)
+}
+
+// Reports whether a value is empty: nil, zero, false, or an empty string, list or map
+func isEmpty(val any) bool {
+	if val == nil {
+		return true
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	}
+	return rv.IsZero()
+}
+
+// default returns the given value, or def if the value is empty.
+// Use as {{ $.input.name | default "web" }}.
+func defaultValue(def any, given ...any) any {
+	if len(given) == 0 || isEmpty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// coalesce returns the first value that isn't empty
+func coalesce(vals ...any) any {
+	for _, val := range vals {
+		if !isEmpty(val) {
+			return val
+		}
+	}
+	return nil
+}
+
+// required fails the template with msg if the value is empty
+func required(msg string, val any) (any, error) {
+	if isEmpty(val) {
+		return nil, errors.New(msg)
+	}
+	return val, nil
+}
+
+// join joins the elements of a list with sep. Use as {{ $.input.zones | join "," }}.
+func join(sep string, list any) (string, error) {
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expected a list, got %T", list)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = toString(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// split splits s into a list around sep. Use as {{ $.input.zones | split "," }}.
+func split(sep string, s any) []any {
+	parts := strings.Split(toString(s), sep)
+	out := make([]any, len(parts))
+	for i, part := range parts {
+		out[i] = part
+	}
+	return out
+}
+
+// replace replaces every old in s with new. Use as {{ $.input.name | replace "_" "-" }}.
+func replace(old, repl string, s any) string {
+	return strings.ReplaceAll(toString(s), old, repl)
+}
+
+func toJSON(val any) (string, error) {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func fromJSON(s any) (any, error) {
+	var val any
+	if err := json.Unmarshal([]byte(toString(s)), &val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
+
+func sha256Sum(s any) string {
+	sum := sha256.Sum256([]byte(toString(s)))
+	return hex.EncodeToString(sum[:])
+}
+
+func b64dec(s any) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(toString(s))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cidrsubnet calculates a subnet of a CIDR range, extending its prefix by newbits
+// and numbering the subnet with netnum. cidrsubnet "10.0.0.0/16" 8 2 is "10.0.2.0/24".
+func cidrSubnet(prefix, newbits, netnum any) (string, error) {
+	p, err := netip.ParsePrefix(toString(prefix))
+	if err != nil {
+		return "", fmt.Errorf("cidrsubnet: %v", err)
+	}
+	bits, err := toInt(newbits)
+	if err != nil {
+		return "", fmt.Errorf("cidrsubnet: newbits: %v", err)
+	}
+	num, err := toInt(netnum)
+	if err != nil {
+		return "", fmt.Errorf("cidrsubnet: netnum: %v", err)
+	}
+	p = p.Masked()
+	newLen := p.Bits() + bits
+	if bits < 0 || newLen > p.Addr().BitLen() {
+		return "", fmt.Errorf("cidrsubnet: cannot extend /%d prefix by %d bits", p.Bits(), bits)
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+	if num < 0 || big.NewInt(int64(num)).Cmp(limit) >= 0 {
+		return "", fmt.Errorf("cidrsubnet: netnum %d does not fit in %d bits", num, bits)
+	}
+	offset := new(big.Int).Lsh(big.NewInt(int64(num)), uint(p.Addr().BitLen()-newLen))
+	return netip.PrefixFrom(addToAddr(p.Addr(), offset), newLen).String(), nil
+}
+
+// cidrhost calculates the address of a host in a CIDR range. Negative host numbers
+// count back from the end of the range. cidrhost "10.0.1.0/24" 5 is "10.0.1.5".
+func cidrHost(prefix, hostnum any) (string, error) {
+	p, err := netip.ParsePrefix(toString(prefix))
+	if err != nil {
+		return "", fmt.Errorf("cidrhost: %v", err)
+	}
+	num, err := toInt(hostnum)
+	if err != nil {
+		return "", fmt.Errorf("cidrhost: hostnum: %v", err)
+	}
+	p = p.Masked()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(p.Addr().BitLen()-p.Bits()))
+	offset := big.NewInt(int64(num))
+	if num < 0 {
+		offset.Add(offset, size)
+	}
+	if offset.Sign() < 0 || offset.Cmp(size) >= 0 {
+		return "", fmt.Errorf("cidrhost: host number %d is outside of %s", num, p)
+	}
+	return addToAddr(p.Addr(), offset).String(), nil
+}
+
+// Adds offset to an IP address
+func addToAddr(addr netip.Addr, offset *big.Int) netip.Addr {
+	raw := addr.AsSlice()
+	sum := new(big.Int).Add(new(big.Int).SetBytes(raw), offset)
+	out := sum.FillBytes(make([]byte, len(raw)))
+	res, _ := netip.AddrFromSlice(out)
+	return res
+}
+
+func toString(val any) string {
+	if s, ok := val.(string); ok {
+		return s
+	}
+	return fmt.Sprint(val)
+}
+
+func toInt(val any) (int, error) {
+	switch v := val.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case string:
+		return strconv.Atoi(v)
+	}
+	return 0, fmt.Errorf("expected an integer, got %v", val)
+}
@@ -0,0 +1,73 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	// Resolves a single template against a few inputs
+	resolve := func(t *testing.T, tmpl string) (any, error) {
+		s := &stack.Stack{
+			Inputs: map[string]stack.Input{
+				"name":  {Type: "string", Default: "web_app"},
+				"empty": {Type: "string", Default: ""},
+				"zones": {Type: "list(string)", Default: []any{"a", "b"}},
+				"cidr":  {Type: "string", Default: "10.0.0.0/16"},
+			},
+			Provider: stack.Provider{Properties: map[string]any{"value": tmpl}},
+		}
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return resolved.Provider.Properties["value"], nil
+	}
+
+	cases := []struct {
+		tmpl     string
+		expected any
+	}{
+		{tmpl: `{{ $.input.empty | default "fallback" }}`, expected: "fallback"},
+		{tmpl: `{{ $.input.name | default "fallback" }}`, expected: "web_app"},
+		{tmpl: `{{ coalesce $.input.empty "" "x" }}`, expected: "x"},
+		{tmpl: `{{ $.input.name | upper }}`, expected: "WEB_APP"},
+		{tmpl: `{{ $.input.name | replace "_" "-" }}`, expected: "web-app"},
+		{tmpl: `{{ $.input.zones | join "," }}`, expected: "a,b"},
+		{tmpl: `{{ "a,b" | split "," }}`, expected: []any{"a", "b"}},
+		{tmpl: `{{ $.input.zones | toJson }}`, expected: `["a","b"]`},
+		{tmpl: `{{ fromJson "{\"a\": 1}" }}`, expected: map[string]any{"a": float64(1)}},
+		{tmpl: `{{ b64enc "hi" }}`, expected: "aGk="},
+		{tmpl: `{{ sha256 "hi" | printf "%.8s" }}`, expected: "8f434346"},
+		{tmpl: `{{ cidrsubnet $.input.cidr 8 2 }}`, expected: "10.0.2.0/24"},
+		{tmpl: `{{ cidrhost (cidrsubnet $.input.cidr 8 1) 5 }}`, expected: "10.0.1.5"},
+		{tmpl: `{{ cidrhost "10.0.1.0/24" -2 }}`, expected: "10.0.1.254"},
+		{tmpl: `{{ cidrsubnet "fd00::/48" 16 3 }}`, expected: "fd00:0:0:3::/64"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			val, err := resolve(t, tt.tmpl)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, val)
+		})
+	}
+
+	t.Run("required", func(t *testing.T) {
+		_, err := resolve(t, `{{ required "name is needed" $.input.empty }}`)
+		assert.ErrorContains(t, err, "name is needed")
+	})
+
+	t.Run("cidrsubnet out of range", func(t *testing.T) {
+		_, err := resolve(t, `{{ cidrsubnet $.input.cidr 2 4 }}`)
+		assert.ErrorContains(t, err, "netnum 4 does not fit in 2 bits")
+	})
+
+	t.Run("unknown functions", func(t *testing.T) {
+		_, err := stack.ExtractVariablePaths(`{{ $.input.name | uppr | trimm }}`)
+		assert.ErrorIs(t, err, stack.ErrUnknownFunction)
+		assert.EqualError(t, err, "unknown function 'uppr', 'trimm'")
+	})
+}
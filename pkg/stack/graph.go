@@ -0,0 +1,312 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// Graph is the dependency graph of the steps of a stack. A step depends on the
+// steps whose registered variables it references, in its params, condition,
+// for_each or count or in the condition of its layer, and on the steps listed
+// in its depends_on. Layers don't order steps, only dependencies do. Steps that
+// use a component are replaced by the steps of the component.
+type Graph struct {
+	// Every step of the stack, in the order they are defined
+	Nodes []*Node
+}
+
+// Node is a step in the dependency graph
+type Node struct {
+	Step  *Step
+	Layer *Layer
+	// Where the step is defined in the stack
+	LayerIndex int
+	StepIndex  int
+	// The steps this step depends on, and the steps that depend on it
+	DependsOn  []Dependency
+	Dependents []*Node
+	// Position in Graph.Nodes
+	index int
+}
+
+// Dependency is an edge of the dependency graph
+type Dependency struct {
+	Node *Node
+	// The registered variable the dependency was inferred from, or empty if the
+	// dependency is listed in depends_on
+	Variable string
+}
+
+// String returns the quoted name of the node's step
+func (n *Node) String() string {
+	return "'" + n.Step.Name + "'"
+}
+
+// Graph builds the dependency graph of the stack's steps. It fails if a step
+// depends on a step that doesn't exist, or on a step name shared by several
+// steps. Use Order to check the graph for cycles.
+func (s *Stack) Graph() (*Graph, error) {
+	var diags Diagnostics
+	g := s.graph(&diags)
+	if err := diags.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Builds the dependency graph, reporting invalid depends_on entries to diags
+func (s *Stack) graph(diags *Diagnostics) *Graph {
+	g := &Graph{}
+	byName := make(map[string][]*Node)
+	byVariable := make(map[string]*Node)
+	// Uses of components whose dependencies were already reported
+	reported := make(map[*componentUse]bool)
+	for i := range s.Layers {
+		for j := range s.Layers[i].Steps {
+			step := &s.Layers[i].Steps[j]
+			n := &Node{Step: step, Layer: &s.Layers[i], LayerIndex: i, StepIndex: j, index: len(g.Nodes)}
+			g.Nodes = append(g.Nodes, n)
+			byName[step.Name] = append(byName[step.Name], n)
+			if step.Register != "" {
+				byVariable[step.Register] = n
+			}
+		}
+	}
+
+	for _, n := range g.Nodes {
+		// Dependencies inferred from references to registered variables
+		for _, parts := range n.references() {
+			if name, ok := registeredName(byVariable, parts); ok {
+				n.addDependency(Dependency{Node: byVariable[name], Variable: name})
+			}
+		}
+		// Explicit dependencies
+		for i, name := range n.Step.DependsOn {
+			pos := n.Step.positions.at(fmt.Sprintf("depends_on[%d]", i), n.Step.Pos)
+			switch deps := byName[name]; {
+			case len(deps) == 1:
+				n.addDependency(Dependency{Node: deps[0]})
+			case n.Step.use != nil:
+				// Reported when validating the component
+			case len(deps) == 0:
+				diags.errorf(pos, CodeUnknownDependency, "step '%s' in %s depends on unknown step '%s'", n.Step.Name, n.Layer.describe(), name)
+			default:
+				diags.errorf(pos, CodeUnknownDependency, "step '%s' in %s depends on '%s', which is the name of %d steps", n.Step.Name, n.Layer.describe(), name, len(deps))
+			}
+		}
+		// The steps of a component depend on everything the step using it
+		// depends on
+		if use := n.Step.use; use != nil {
+			for i, name := range use.step.DependsOn {
+				pos := use.step.positions.at(fmt.Sprintf("depends_on[%d]", i), use.step.Pos)
+				switch deps := byName[name]; {
+				case len(deps) == 1:
+					n.addDependency(Dependency{Node: deps[0]})
+				case reported[use]:
+				case len(deps) == 0:
+					diags.errorf(pos, CodeUnknownDependency, "step '%s' in %s depends on unknown step '%s'", use.step.Name, n.Layer.describe(), name)
+				default:
+					diags.errorf(pos, CodeUnknownDependency, "step '%s' in %s depends on '%s', which is the name of %d steps", use.step.Name, n.Layer.describe(), name, len(deps))
+				}
+			}
+			reported[use] = true
+		}
+	}
+	return g
+}
+
+// Returns the references of a step to registered variables. References made by
+// the steps of a component are prefixed with the namespace of its use, and
+// include the references of the using step's condition and arguments.
+func (n *Node) references() [][]string {
+	var refs [][]string
+	seen := make(map[string]bool)
+	add := func(val any, namespace string) {
+		for _, parts := range templateReferences(val) {
+			if len(parts) == 0 || parts[0] == "input" || parts[0] == "secret" || parts[0] == "each" || parts[0] == "local" {
+				continue
+			}
+			if namespace != "" {
+				parts = append([]string{namespace}, parts...)
+			}
+			if key := strings.Join(parts, "."); !seen[key] {
+				seen[key] = true
+				refs = append(refs, parts)
+			}
+		}
+	}
+	namespace := ""
+	if use := n.Step.use; use != nil {
+		namespace = use.namespace
+		add(use.step.With, "")
+		add(use.step.When, "")
+	}
+	for _, val := range []any{n.Step.Params, n.Step.When, n.Step.ForEach, n.Step.Count} {
+		add(val, namespace)
+	}
+	add(n.Layer.When, "")
+	return refs
+}
+
+// Adds a dependency unless the node already depends on the same step
+func (n *Node) addDependency(dep Dependency) {
+	for _, existing := range n.DependsOn {
+		if existing.Node == dep.Node {
+			return
+		}
+	}
+	n.DependsOn = append(n.DependsOn, dep)
+	dep.Node.Dependents = append(dep.Node.Dependents, n)
+}
+
+// Cycle returns a cycle in the graph as a path that starts and ends with the
+// same step, or nil if the graph has no cycles
+func (g *Graph) Cycle() []*Node {
+	return g.cycle(false)
+}
+
+// Finds a cycle with a depth-first search, optionally ignoring steps that
+// depend on themselves through references to their own variable. Steps that
+// list themselves in depends_on are always a cycle.
+func (g *Graph) cycle(ignoreSelf bool) []*Node {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(g.Nodes))
+	var path []*Node
+	var visit func(n *Node) []*Node
+	visit = func(n *Node) []*Node {
+		state[n.index] = visiting
+		path = append(path, n)
+		for _, dep := range n.DependsOn {
+			if ignoreSelf && dep.Node == n && dep.Variable != "" {
+				continue
+			}
+			switch state[dep.Node.index] {
+			case visiting:
+				// Cut the path down to the cycle
+				for i, p := range path {
+					if p == dep.Node {
+						return append(append([]*Node{}, path[i:]...), dep.Node)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep.Node); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[n.index] = visited
+		return nil
+	}
+	for _, n := range g.Nodes {
+		if state[n.index] == unvisited {
+			if cycle := visit(n); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// Order returns the steps in an order they can run in, with every step after
+// the steps it depends on. Otherwise, steps keep the order they are defined in.
+// It fails with ErrDependencyCycle if the graph has a cycle.
+func (g *Graph) Order() ([]*Node, error) {
+	if cycle := g.Cycle(); cycle != nil {
+		return nil, cycleError(cycle)
+	}
+	done := make([]bool, len(g.Nodes))
+	order := make([]*Node, 0, len(g.Nodes))
+	for len(order) < len(g.Nodes) {
+		// Take the first step defined whose dependencies are all done
+		for _, n := range g.Nodes {
+			if done[n.index] || !n.ready(done) {
+				continue
+			}
+			done[n.index] = true
+			order = append(order, n)
+			break
+		}
+	}
+	return order, nil
+}
+
+// Reports whether all dependencies of a step are done
+func (n *Node) ready(done []bool) bool {
+	for _, dep := range n.DependsOn {
+		if !done[dep.Node.index] {
+			return false
+		}
+	}
+	return true
+}
+
+// Levels groups the steps into levels that can run one after the other. The
+// steps of a level only depend on steps of earlier levels, so they can run
+// together. It fails with ErrDependencyCycle if the graph has a cycle.
+func (g *Graph) Levels() ([][]*Node, error) {
+	if cycle := g.Cycle(); cycle != nil {
+		return nil, cycleError(cycle)
+	}
+	// A step's level is one more than the highest level it depends on
+	levelOf := make([]int, len(g.Nodes))
+	var level func(n *Node) int
+	level = func(n *Node) int {
+		if levelOf[n.index] > 0 {
+			return levelOf[n.index]
+		}
+		l := 1
+		for _, dep := range n.DependsOn {
+			l = max(l, level(dep.Node)+1)
+		}
+		levelOf[n.index] = l
+		return l
+	}
+	var levels [][]*Node
+	for _, n := range g.Nodes {
+		l := level(n)
+		for len(levels) < l {
+			levels = append(levels, nil)
+		}
+		levels[l-1] = append(levels[l-1], n)
+	}
+	return levels, nil
+}
+
+// Returns an error describing a cycle, like "dependency cycle: 'a' -> 'b' -> 'a'",
+// where each step depends on the next
+func cycleError(cycle []*Node) error {
+	names := make([]string, len(cycle))
+	for i, n := range cycle {
+		names[i] = n.String()
+	}
+	return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(names, " -> "))
+}
+
+// Returns the references of every template below val
+func templateReferences(val any) [][]string {
+	var refs [][]string
+	switch v := val.(type) {
+	case string:
+		if strings.Contains(v, "{{") {
+			paths, _ := ExtractVariablePaths(v)
+			refs = append(refs, paths...)
+		}
+	case map[string]any:
+		for _, key := range sortedKeys(v) {
+			refs = append(refs, templateReferences(v[key])...)
+		}
+	case []any:
+		for _, item := range v {
+			refs = append(refs, templateReferences(item)...)
+		}
+	}
+	return refs
+}
@@ -0,0 +1,112 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const graphStack = `
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: compute
+    steps:
+      - name: Launch Instance
+        aws.ec2:
+          subnet_id: "{{ $.my_subnet.id }}"
+          security_groups: ["{{ $.web_sg.id }}"]
+      - name: Create Security Group
+        aws.security_group:
+          name: web
+        register: web_sg
+  - name: networking
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: main
+        register: my_vpc
+      - name: Create Subnet
+        aws.subnet:
+          vpc_id: "{{ $.my_vpc.id }}"
+        register: my_subnet
+      - name: Create Bucket
+        depends_on: [Create VPC]
+        aws.s3_bucket:
+          name: logs
+`
+
+// Returns the step names of graph nodes
+func stepNames(nodes []*stack.Node) []string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.Step.Name
+	}
+	return names
+}
+
+func TestStackGraph(t *testing.T) {
+	t.Run("infers dependencies from references", func(t *testing.T) {
+		s, err := stack.Parse([]byte(graphStack))
+		require.NoError(t, err)
+		g, err := s.Graph()
+		require.NoError(t, err)
+		require.Len(t, g.Nodes, 5)
+
+		launch := g.Nodes[0]
+		require.Len(t, launch.DependsOn, 2)
+		assert.Equal(t, "Create Security Group", launch.DependsOn[0].Node.Step.Name)
+		assert.Equal(t, "web_sg", launch.DependsOn[0].Variable)
+		assert.Equal(t, "Create Subnet", launch.DependsOn[1].Node.Step.Name)
+
+		bucket := g.Nodes[4]
+		require.Len(t, bucket.DependsOn, 1)
+		assert.Equal(t, "", bucket.DependsOn[0].Variable)
+		assert.Equal(t, []string{"Create Subnet", "Create Bucket"}, stepNames(g.Nodes[2].Dependents))
+	})
+
+	t.Run("orders steps by their dependencies", func(t *testing.T) {
+		s, err := stack.Parse([]byte(graphStack))
+		require.NoError(t, err)
+		g, err := s.Graph()
+		require.NoError(t, err)
+
+		order, err := g.Order()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Create Security Group", "Create VPC", "Create Subnet", "Launch Instance", "Create Bucket"}, stepNames(order))
+
+		levels, err := g.Levels()
+		require.NoError(t, err)
+		require.Len(t, levels, 3)
+		assert.Equal(t, []string{"Create Security Group", "Create VPC"}, stepNames(levels[0]))
+		assert.Equal(t, []string{"Create Subnet", "Create Bucket"}, stepNames(levels[1]))
+		assert.Equal(t, []string{"Launch Instance"}, stepNames(levels[2]))
+	})
+
+	t.Run("detects cycles", func(t *testing.T) {
+		s, err := stack.Parse([]byte(graphStack))
+		require.NoError(t, err)
+		s.Layers[1].Steps[0].DependsOn = []string{"Launch Instance"}
+		g, err := s.Graph()
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"Launch Instance", "Create Subnet", "Create VPC", "Launch Instance"}, stepNames(g.Cycle()))
+		_, err = g.Order()
+		assert.ErrorIs(t, err, stack.ErrDependencyCycle)
+		assert.EqualError(t, err, "dependency cycle: 'Launch Instance' -> 'Create Subnet' -> 'Create VPC' -> 'Launch Instance'")
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{Lenient: true})
+		assert.ErrorIs(t, err, stack.ErrDependencyCycle)
+	})
+
+	t.Run("fails on unknown dependencies", func(t *testing.T) {
+		s, err := stack.ParseFile("test.yml", []byte(graphStack))
+		require.NoError(t, err)
+		s.Layers[1].Steps[2].DependsOn = []string{"Create Database"}
+		_, err = s.Graph()
+		assert.EqualError(t, err, "test.yml:28:22: step 'Create Bucket' in layer 'networking' depends on unknown step 'Create Database'")
+	})
+}
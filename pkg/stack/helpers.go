@@ -3,30 +3,89 @@ package stack
 import (
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"text/template"
 	"text/template/parse"
 )
 
-var ErrTemplateSyntax = errors.New("invalid template syntax")
+var (
+	ErrTemplateSyntax  = errors.New("invalid template syntax")
+	ErrUnknownFunction = errors.New("unknown function")
+)
+
+// Limits how deeply {{ template }} calls are followed, to stop recursive templates
+const maxTemplateDepth = 16
 
+// ExtractVariablePaths returns the path of every variable referenced in a
+// template, relative to the stack's root context. Both "$.input.region" and
+// ".input.region" result in ["input", "region"]. References inside if, range,
+// with and template actions are included, following how range and with rebind
+// the dot. Values of range elements can't be addressed, so references through
+// them result in the path of the ranged over value.
 func ExtractVariablePaths(tmplStr string) ([][]string, error) {
-	tmpl, err := template.New("tmpl").Parse(tmplStr)
+	tmpl, err := parseTemplate(tmplStr)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrTemplateSyntax, err)
+		return nil, err
 	}
+	e := refExtractor{tmpl: tmpl}
+	root := &refBinding{}
+	e.walk(tmpl.Tree.Root, &refScope{dot: root, vars: map[string]*refBinding{"$": root}})
+	return e.paths, nil
+}
 
-	var variables [][]string
+// Parses a template string with the stack template functions. Calls to functions
+// that don't exist are reported as ErrUnknownFunction, listing all of them.
+func parseTemplate(tmplStr string) (*template.Template, error) {
+	tmpl, err := template.New("tmpl").Funcs(templateFuncs).Parse(tmplStr)
+	if err == nil {
+		return tmpl, nil
+	}
+	if names := unknownFunctions(tmplStr); len(names) > 0 {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownFunction, strings.Join(names, "', '"))
+	}
+	return nil, fmt.Errorf("%w: %v", ErrTemplateSyntax, err)
+}
 
+// Returns the names of all functions called in a template that aren't available
+// to stack templates
+func unknownFunctions(tmplStr string) []string {
+	tree := parse.New("tmpl")
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(tmplStr, "", "", trees); err != nil {
+		return nil
+	}
+	var names []string
 	var walk func(node parse.Node)
 	walk = func(node parse.Node) {
 		switch n := node.(type) {
 		case *parse.ListNode:
+			if n == nil {
+				return
+			}
 			for _, sub := range n.Nodes {
 				walk(sub)
 			}
 		case *parse.ActionNode:
 			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
 		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
 			for _, cmd := range n.Cmds {
 				walk(cmd)
 			}
@@ -34,14 +93,201 @@ func ExtractVariablePaths(tmplStr string) ([][]string, error) {
 			for _, arg := range n.Args {
 				walk(arg)
 			}
-		case *parse.VariableNode:
-			if len(n.Ident) > 0 {
-				// Filter out the dollar sign
-				variables = append(variables, n.Ident[1:])
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.IdentifierNode:
+			if !isTemplateFunc(n.Ident) && !slices.Contains(names, n.Ident) {
+				names = append(names, n.Ident)
 			}
 		}
 	}
+	for _, name := range sortedKeys(trees) {
+		walk(trees[name].Root)
+	}
+	return names
+}
+
+// refBinding is what the dot or a template variable refers to
+type refBinding struct {
+	path []string
+	// Opaque bindings are elements of a ranged over value, fields of which
+	// can't be addressed and resolve to the value's own path
+	opaque bool
+}
+
+// Returns the path of a field below the binding
+func (b *refBinding) field(idents []string) []string {
+	if b.opaque {
+		return b.path
+	}
+	path := make([]string, 0, len(b.path)+len(idents))
+	path = append(path, b.path...)
+	return append(path, idents...)
+}
+
+// refScope tracks the dot and all declared variables at a point in the template.
+// A nil binding means the value isn't a stack reference, e.g. a function result.
+type refScope struct {
+	dot  *refBinding
+	vars map[string]*refBinding
+}
+
+// Returns a copy of the scope with a new dot, so that variables declared inside
+// a control structure don't leak out of it
+func (s *refScope) with(dot *refBinding) *refScope {
+	vars := make(map[string]*refBinding, len(s.vars))
+	for name, b := range s.vars {
+		vars[name] = b
+	}
+	return &refScope{dot: dot, vars: vars}
+}
 
-	walk(tmpl.Tree.Root)
-	return variables, nil
+type refExtractor struct {
+	tmpl  *template.Template
+	paths [][]string
+	depth int
+}
+
+func (e *refExtractor) walk(node parse.Node, scope *refScope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, sub := range n.Nodes {
+			e.walk(sub, scope)
+		}
+	case *parse.ActionNode:
+		e.pipe(n.Pipe, scope)
+	case *parse.IfNode:
+		// Variables declared in the condition are visible in both branches
+		inner := scope.with(scope.dot)
+		e.pipe(n.Pipe, inner)
+		e.walk(n.List, inner.with(inner.dot))
+		e.walk(n.ElseList, inner.with(inner.dot))
+	case *parse.WithNode:
+		inner := scope.with(scope.dot)
+		val := e.pipe(n.Pipe, inner)
+		inner.dot = val
+		e.walk(n.List, inner)
+		e.walk(n.ElseList, scope.with(scope.dot))
+	case *parse.RangeNode:
+		inner := scope.with(scope.dot)
+		val := e.pipe(n.Pipe, inner)
+		var elem *refBinding
+		if val != nil {
+			elem = &refBinding{path: val.path, opaque: true}
+		}
+		// Range declares the element, or the key and element, as variables
+		for _, decl := range n.Pipe.Decl {
+			inner.vars[decl.Ident[0]] = elem
+		}
+		if len(n.Pipe.Decl) == 2 {
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = nil
+		}
+		inner.dot = elem
+		e.walk(n.List, inner)
+		e.walk(n.ElseList, scope.with(scope.dot))
+	case *parse.TemplateNode:
+		var dot *refBinding
+		if n.Pipe != nil {
+			dot = e.pipe(n.Pipe, scope)
+		}
+		// Named templates are walked with the dot they are called with, which
+		// is also what $ refers to inside of them
+		if t := e.tmpl.Lookup(n.Name); t != nil && t.Tree != nil && e.depth < maxTemplateDepth {
+			e.depth++
+			e.walk(t.Tree.Root, &refScope{dot: dot, vars: map[string]*refBinding{"$": dot}})
+			e.depth--
+		}
+	}
+}
+
+// Collects the references in a pipeline and returns what the pipeline's value
+// refers to. Variables declared by the pipeline are added to the scope.
+func (e *refExtractor) pipe(pipe *parse.PipeNode, scope *refScope) *refBinding {
+	if pipe == nil {
+		return nil
+	}
+	var val *refBinding
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			e.arg(arg, scope)
+		}
+	}
+	// Only a pipeline of a single reference has a value that refers to the stack
+	if len(pipe.Cmds) == 1 && len(pipe.Cmds[0].Args) == 1 {
+		val = e.binding(pipe.Cmds[0].Args[0], scope)
+	}
+	for _, decl := range pipe.Decl {
+		scope.vars[decl.Ident[0]] = val
+	}
+	return val
+}
+
+// Collects the references in a single command argument
+func (e *refExtractor) arg(node parse.Node, scope *refScope) {
+	switch n := node.(type) {
+	case *parse.PipeNode:
+		e.pipe(n, scope)
+	case *parse.ChainNode:
+		if _, ok := n.Node.(*parse.PipeNode); ok {
+			e.arg(n.Node, scope)
+		}
+		e.record(e.binding(n, scope))
+	case *parse.FieldNode, *parse.VariableNode, *parse.DotNode:
+		e.record(e.binding(n, scope))
+	}
+}
+
+func (e *refExtractor) record(b *refBinding) {
+	if b != nil {
+		e.paths = append(e.paths, b.path)
+	}
+}
+
+// Returns what a reference node refers to, or nil if it isn't a stack reference
+func (e *refExtractor) binding(node parse.Node, scope *refScope) *refBinding {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return scope.dot
+	case *parse.FieldNode:
+		if scope.dot == nil {
+			return nil
+		}
+		return &refBinding{path: scope.dot.field(n.Ident)}
+	case *parse.VariableNode:
+		b := scope.vars[n.Ident[0]]
+		if b == nil {
+			return nil
+		}
+		if len(n.Ident) == 1 {
+			return b
+		}
+		return &refBinding{path: b.field(n.Ident[1:])}
+	case *parse.ChainNode:
+		var base *refBinding
+		if pipe, ok := n.Node.(*parse.PipeNode); ok {
+			if len(pipe.Cmds) == 1 && len(pipe.Cmds[0].Args) == 1 {
+				base = e.binding(pipe.Cmds[0].Args[0], scope)
+			}
+		} else {
+			base = e.binding(n.Node, scope)
+		}
+		if base == nil {
+			return nil
+		}
+		return &refBinding{path: base.field(n.Field)}
+	}
+	return nil
+}
+
+// Returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
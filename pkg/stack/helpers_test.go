@@ -29,6 +29,41 @@ func TestExtractVariablePaths(t *testing.T) {
 				input:    `{{ $.my_var.prop }}`,
 				expected: [][]string{{"my_var", "prop"}},
 			},
+			{
+				name:     "dot rooted field",
+				input:    `{{ .input.region }}`,
+				expected: [][]string{{"input", "region"}},
+			},
+			{
+				name:     "if and else",
+				input:    `{{ if eq $.input.env "prod" }}{{ $.input.big }}{{ else }}{{ .input.small }}{{ end }}`,
+				expected: [][]string{{"input", "env"}, {"input", "big"}, {"input", "small"}},
+			},
+			{
+				name:     "with rebinds dot",
+				input:    `{{ with $.my_vpc }}{{ .id }}{{ else }}{{ .input.vpc_id }}{{ end }}`,
+				expected: [][]string{{"my_vpc"}, {"my_vpc", "id"}, {"input", "vpc_id"}},
+			},
+			{
+				name:     "range elements",
+				input:    `{{ range $i, $sg := $.input.sgs }}{{ $sg.id }}{{ .name }}{{ $.input.prefix }}{{ end }}`,
+				expected: [][]string{{"input", "sgs"}, {"input", "sgs"}, {"input", "sgs"}, {"input", "prefix"}},
+			},
+			{
+				name:     "declared variables",
+				input:    `{{ $vpc := $.my_vpc }}{{ $vpc.id }}`,
+				expected: [][]string{{"my_vpc"}, {"my_vpc", "id"}},
+			},
+			{
+				name:     "nested pipelines and chains",
+				input:    `{{ printf "%s-%s" ($.input.env) (index $.my_subnet.ids 0) }}{{ ($.my_vpc).cidr }}`,
+				expected: [][]string{{"input", "env"}, {"my_subnet", "ids"}, {"my_vpc"}, {"my_vpc", "cidr"}},
+			},
+			{
+				name:     "named templates",
+				input:    `{{ define "tag" }}{{ .name }}-{{ $.env }}{{ end }}{{ template "tag" $.input }}`,
+				expected: [][]string{{"input"}, {"input", "name"}, {"input", "env"}},
+			},
 		}
 		for _, tt := range cases {
 			t.Run(tt.name, func(t *testing.T) {
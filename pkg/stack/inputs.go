@@ -0,0 +1,114 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUndefinedInput  = errors.New("undefined input")
+	ErrMissingInput    = errors.New("missing required input")
+	ErrValueNotAllowed = errors.New("value is not allowed")
+)
+
+// PrepareInputs turns supplied input values into the full set of inputs used to
+// resolve the stack. Values are converted and checked like ConvertInputs, then
+// defaults are applied, required inputs are enforced and allowed lists are checked.
+func (s *Stack) PrepareInputs(values map[string]any) (map[string]any, error) {
+	out, err := s.ConvertInputs(values)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, name := range sortedKeys(s.Inputs) {
+		input := s.Inputs[name]
+		val, ok := out[name]
+		if !ok {
+			if input.Required || input.Default == nil {
+				errs = append(errs, fmt.Errorf("%w '%s'", ErrMissingInput, name))
+				continue
+			}
+			t, err := ParseType(input.Type)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("input '%s': %w", name, err))
+				continue
+			}
+			if val, err = t.Convert(input.Default); err != nil {
+				errs = append(errs, fmt.Errorf("input '%s': invalid default: %w", name, err))
+				continue
+			}
+			out[name] = val
+		}
+		if err := input.CheckAllowed(val); err != nil {
+			errs = append(errs, fmt.Errorf("input '%s': %w", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// CheckAllowed checks a converted value against the input's allowed list, if it has one
+func (i Input) CheckAllowed(val any) error {
+	if len(i.Allowed) == 0 {
+		return nil
+	}
+	t, err := ParseType(i.Type)
+	if err != nil {
+		return err
+	}
+	options := make([]string, 0, len(i.Allowed))
+	for _, allowed := range i.Allowed {
+		allowedValue, err := t.Convert(allowed.Value)
+		if err == nil && containsValue([]any{allowedValue}, val) {
+			return nil
+		}
+		options = append(options, fmt.Sprint(allowed.Value))
+	}
+	return fmt.Errorf("%w: %v must be one of %s", ErrValueNotAllowed, val, strings.Join(options, ", "))
+}
+
+// ConvertInputs converts supplied input values to the types declared by the
+// stack and checks them against each input's constraints. Values for inputs
+// that the stack doesn't declare are rejected.
+func (s *Stack) ConvertInputs(values map[string]any) (map[string]any, error) {
+	out := make(map[string]any, len(values))
+	var errs []error
+	for _, name := range sortedKeys(values) {
+		input, ok := s.Inputs[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w '%s'", ErrUndefinedInput, name))
+			continue
+		}
+		val, err := input.Convert(values[name])
+		if err != nil {
+			for _, err := range unwrapJoined(err) {
+				errs = append(errs, fmt.Errorf("input '%s': %w", name, err))
+			}
+			continue
+		}
+		out[name] = val
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// Convert converts a value to the input's declared type and checks it against
+// the input's constraints
+func (i Input) Convert(val any) (any, error) {
+	t, err := ParseType(i.Type)
+	if err != nil {
+		return nil, err
+	}
+	if val, err = t.Convert(val); err != nil {
+		return nil, err
+	}
+	if err := i.CheckConstraints(val); err != nil {
+		return nil, err
+	}
+	return val, nil
+}
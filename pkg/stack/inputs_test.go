@@ -0,0 +1,107 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertInputs(t *testing.T) {
+	s := &stack.Stack{
+		Inputs: map[string]stack.Input{
+			"replicas": {Type: "integer", Default: 1},
+			"public":   {Type: "bool", Default: false},
+		},
+	}
+
+	t.Run("converts to declared types", func(t *testing.T) {
+		vals, err := s.ConvertInputs(map[string]any{"replicas": "3", "public": "true"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"replicas": 3, "public": true}, vals)
+	})
+
+	t.Run("rejects invalid and unknown inputs", func(t *testing.T) {
+		_, err := s.ConvertInputs(map[string]any{"replicas": "many", "region": "us-east-1"})
+		assert.ErrorIs(t, err, stack.ErrUndefinedInput)
+		assert.ErrorContains(t, err, `input 'replicas': expected integer, got string "many"`)
+	})
+}
+
+func TestCheckConstraints(t *testing.T) {
+	minCount, maxCount := 1.0, 5.0
+	minLen, maxLen := 3, 8
+
+	t.Run("values within constraints", func(t *testing.T) {
+		input := stack.Input{Type: "list(integer)", Min: &minCount, Max: &maxCount, Unique: true}
+		assert.NoError(t, input.CheckConstraints([]any{1, 2, 5}))
+	})
+
+	t.Run("each broken constraint is reported", func(t *testing.T) {
+		input := stack.Input{
+			Type:      "string",
+			Pattern:   `^web-`,
+			MinLength: &minLen,
+			MaxLength: &maxLen,
+		}
+		err := input.CheckConstraints("db")
+		assert.ErrorContains(t, err, `value "db" does not match pattern '^web-'`)
+		assert.ErrorContains(t, err, "length 2 is less than the minimum length of 3")
+		var constraintErr *stack.ConstraintError
+		require.ErrorAs(t, err, &constraintErr)
+		assert.Equal(t, stack.ConstraintPattern, constraintErr.Constraint)
+	})
+
+	t.Run("custom messages", func(t *testing.T) {
+		input := stack.Input{
+			Type:     "list(string)",
+			Pattern:  `^10\.`,
+			Unique:   true,
+			Messages: map[string]string{"pattern": "CIDRs must be in 10.0.0.0/8"},
+		}
+		err := input.CheckConstraints([]any{"10.0.0.0/24", "192.168.0.0/24", "10.0.0.0/24"})
+		assert.EqualError(t, err, "CIDRs must be in 10.0.0.0/8\nvalue 10.0.0.0/24 appears more than once")
+	})
+
+	t.Run("supplied values are checked", func(t *testing.T) {
+		s := &stack.Stack{
+			Inputs: map[string]stack.Input{
+				"count": {Type: "integer", Default: 1, Min: &minCount, Max: &maxCount},
+			},
+		}
+		_, err := s.ConvertInputs(map[string]any{"count": "9"})
+		assert.EqualError(t, err, "input 'count': value 9 is greater than the maximum of 5")
+	})
+}
+
+func TestPrepareInputs(t *testing.T) {
+	s := &stack.Stack{
+		Inputs: map[string]stack.Input{
+			"environment": {
+				Type:     "string",
+				Required: true,
+				Allowed:  []stack.AllowedValue{{Value: "dev"}, {Value: "prod"}},
+			},
+			"replicas": {Type: "integer", Default: 2},
+		},
+	}
+
+	t.Run("applies defaults", func(t *testing.T) {
+		vals, err := s.PrepareInputs(map[string]any{"environment": "dev"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"environment": "dev", "replicas": 2}, vals)
+	})
+
+	t.Run("enforces required inputs", func(t *testing.T) {
+		_, err := s.PrepareInputs(map[string]any{"replicas": 1})
+		assert.ErrorIs(t, err, stack.ErrMissingInput)
+		assert.EqualError(t, err, "missing required input 'environment'")
+	})
+
+	t.Run("checks allowed values", func(t *testing.T) {
+		_, err := s.PrepareInputs(map[string]any{"environment": "qa"})
+		assert.ErrorIs(t, err, stack.ErrValueNotAllowed)
+		assert.EqualError(t, err, "input 'environment': value is not allowed: qa must be one of dev, prod")
+	})
+}
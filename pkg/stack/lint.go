@@ -0,0 +1,720 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+var ErrInvalidLintConfig = errors.New("invalid lint config")
+
+// LintConfigFile is the name of the file lint rules are configured in
+const LintConfigFile = ".groundctl-lint.yaml"
+
+// LintRule is a style or hygiene check. Unlike the problems found by Validate,
+// the problems found by lint rules don't stop a stack from being used.
+type LintRule struct {
+	ID          string
+	Description string
+	// Severity of the problems found, unless configured otherwise
+	Severity Severity
+	// Default values of the rule's options
+	Options map[string]any
+	// Types configured options are converted to
+	optionTypes map[string]Type
+	check       func(l *linter)
+}
+
+// IDs of all lint rules
+const (
+	RuleUnusedInput        = "unused-input"
+	RuleUnusedSecret       = "unused-secret"
+	RuleUnusedVariable     = "unused-variable"
+	RuleSingleUseSecret    = "single-use-secret"
+	RuleDuplicateStepName  = "duplicate-step-name"
+	RuleSnakeCase          = "snake-case"
+	RuleMissingDescription = "missing-description"
+	RuleMissingLabel       = "missing-label"
+	RuleOpenIngress        = "open-ingress"
+)
+
+var lintRules = []LintRule{
+	{
+		ID:          RuleUnusedInput,
+		Description: "inputs that are never referenced",
+		Severity:    SeverityWarning,
+		check:       lintUnusedInputs,
+	},
+	{
+		ID:          RuleUnusedSecret,
+		Description: "secrets that are never referenced",
+		Severity:    SeverityWarning,
+		check:       lintUnusedSecrets,
+	},
+	{
+		ID:          RuleUnusedVariable,
+		Description: "registered variables that are never referenced",
+		Severity:    SeverityWarning,
+		check:       lintUnusedVariables,
+	},
+	{
+		ID:          RuleSingleUseSecret,
+		Description: "secrets that are only referenced once",
+		Severity:    SeverityInfo,
+		check:       lintSingleUseSecrets,
+	},
+	{
+		ID:          RuleDuplicateStepName,
+		Description: "steps with the same name in a layer or component",
+		Severity:    SeverityWarning,
+		check:       lintDuplicateStepNames,
+	},
+	{
+		ID:          RuleSnakeCase,
+		Description: "inputs, secrets, locals, outputs, components and registered variables not named in snake_case",
+		Severity:    SeverityWarning,
+		check:       lintSnakeCase,
+	},
+	{
+		ID:          RuleMissingDescription,
+		Description: "stacks, inputs, secrets, outputs and components without a description",
+		Severity:    SeverityInfo,
+		check:       lintMissingDescriptions,
+	},
+	{
+		ID:          RuleMissingLabel,
+		Description: "inputs and secrets without a label",
+		Severity:    SeverityInfo,
+		check:       lintMissingLabels,
+	},
+	{
+		ID:          RuleOpenIngress,
+		Description: "step params that open ports to 0.0.0.0/0 or ::/0",
+		Severity:    SeverityWarning,
+		Options:     map[string]any{"ports": []any{22}},
+		optionTypes: map[string]Type{"ports": ListType{Elem: TypeInteger}},
+		check:       lintOpenIngress,
+	},
+}
+
+// LintRules returns every lint rule
+func LintRules() []LintRule {
+	return slices.Clone(lintRules)
+}
+
+// LintConfig configures the lint rules, as read from a .groundctl-lint.yaml
+// file:
+//
+//	rules:
+//	  missing-label: off
+//	  unused-input: error
+//	  open-ingress:
+//	    severity: error
+//	    ports: [22, 3389]
+type LintConfig struct {
+	Rules map[string]LintRuleConfig `yaml:"rules"`
+}
+
+// LintRuleConfig configures a single lint rule
+type LintRuleConfig struct {
+	// "error", "warning", "info" or "off", or empty to keep the rule's severity
+	Severity string
+	// Options of the rule, overriding its defaults
+	Options map[string]any
+}
+
+// UnmarshalYAML reads a rule's config from either a severity or a mapping of
+// its severity and options
+func (c *LintRuleConfig) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		return n.Decode(&c.Severity)
+	}
+	if err := n.Decode(&c.Options); err != nil {
+		return err
+	}
+	if severity, ok := c.Options["severity"]; ok {
+		c.Severity = fmt.Sprint(severity)
+		delete(c.Options, "severity")
+	}
+	return nil
+}
+
+// ParseLintConfig reads a lint config and checks that it only configures rules
+// that exist, with valid severities and options
+func ParseLintConfig(data []byte) (LintConfig, error) {
+	var config LintConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("%w: %v", ErrInvalidLintConfig, err)
+	}
+	for _, id := range sortedKeys(config.Rules) {
+		rule, ok := lintRule(id)
+		if !ok {
+			return config, fmt.Errorf("%w: unknown rule '%s'", ErrInvalidLintConfig, id)
+		}
+		ruleConfig := config.Rules[id]
+		if _, _, err := parseSeverity(ruleConfig.Severity); err != nil {
+			return config, fmt.Errorf("%w: rule '%s': %v", ErrInvalidLintConfig, id, err)
+		}
+		options, err := rule.options(ruleConfig)
+		if err != nil {
+			return config, fmt.Errorf("%w: %v", ErrInvalidLintConfig, err)
+		}
+		for name := range ruleConfig.Options {
+			ruleConfig.Options[name] = options[name]
+		}
+	}
+	return config, nil
+}
+
+// Returns the rule's options, with the configured options converted to their
+// types in place of the defaults
+func (r LintRule) options(config LintRuleConfig) (map[string]any, error) {
+	options := make(map[string]any, len(r.Options))
+	for name, val := range r.Options {
+		options[name] = val
+	}
+	for _, name := range sortedKeys(config.Options) {
+		if _, ok := r.Options[name]; !ok {
+			return nil, fmt.Errorf("rule '%s' has no option '%s'", r.ID, name)
+		}
+		val := config.Options[name]
+		if t, ok := r.optionTypes[name]; ok {
+			var err error
+			if val, err = t.Convert(val); err != nil {
+				return nil, fmt.Errorf("option '%s' of rule '%s': %w", name, r.ID, err)
+			}
+		}
+		options[name] = val
+	}
+	return options, nil
+}
+
+// Returns the rule with the given ID
+func lintRule(id string) (LintRule, bool) {
+	for _, rule := range lintRules {
+		if rule.ID == id {
+			return rule, true
+		}
+	}
+	return LintRule{}, false
+}
+
+// Parses a configured severity. Rules with the severity "off" are disabled.
+func parseSeverity(s string) (severity Severity, enabled bool, err error) {
+	switch s {
+	case "error":
+		return SeverityError, true, nil
+	case "warning":
+		return SeverityWarning, true, nil
+	case "info":
+		return SeverityInfo, true, nil
+	case "off":
+		return 0, false, nil
+	case "":
+		return 0, true, nil
+	}
+	return 0, false, fmt.Errorf("invalid severity '%s'", s)
+}
+
+// Lint runs every enabled lint rule over the stack and returns the problems
+// found, ordered by position. Each diagnostic's code is the ID of the rule
+// that found it. Problems can be ignored with a "# groundctl:ignore RULE"
+// comment on the line of the element they are found in, or right above it.
+// Rules configured with a severity or options that ParseLintConfig rejects
+// are skipped.
+func (s *Stack) Lint(config LintConfig) Diagnostics {
+	var diags Diagnostics
+	refs := s.allReferences()
+	for _, rule := range lintRules {
+		ruleConfig := config.Rules[rule.ID]
+		severity, enabled, err := parseSeverity(ruleConfig.Severity)
+		if err != nil || !enabled {
+			continue
+		}
+		if ruleConfig.Severity == "" {
+			severity = rule.Severity
+		}
+		options, err := rule.options(ruleConfig)
+		if err != nil {
+			continue
+		}
+		logrus.WithField("rule", rule.ID).Trace("Running lint rule")
+		l := &linter{stack: s, refs: refs, rule: rule.ID, severity: severity, options: options}
+		rule.check(l)
+		for _, d := range l.diags {
+			if !s.ignored(d) {
+				diags = append(diags, d)
+			}
+		}
+	}
+	diags.Sort()
+	return diags
+}
+
+// linter runs a single lint rule
+type linter struct {
+	stack    *Stack
+	refs     []templateRef
+	rule     string
+	severity Severity
+	options  map[string]any
+	diags    Diagnostics
+}
+
+func (l *linter) report(pos Position, format string, args ...any) {
+	l.diags.add(l.severity, pos, l.rule, format, args...)
+}
+
+// templateRef is a reference made by a template of the stack
+type templateRef struct {
+	parts []string
+	// Name of the component the template belongs to, if any. Components
+	// reference their own inputs and registered variables.
+	component string
+}
+
+// Returns every reference made by the templates of the stack, including the
+// templates of component definitions. The steps of used components are only
+// included once, with their component.
+func (s *Stack) allReferences() []templateRef {
+	var refs []templateRef
+	add := func(component string, vals ...any) {
+		for _, val := range vals {
+			for _, parts := range templateReferences(val) {
+				refs = append(refs, templateRef{parts: parts, component: component})
+			}
+		}
+	}
+	stepRefs := func(component string, step *Step) {
+		add(component, step.Name, step.Tags, step.Params, step.When, step.ForEach, step.Count)
+	}
+	add("", s.Provider.Properties)
+	for _, name := range sortedKeys(s.Locals) {
+		add("", s.Locals[name])
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		add("", s.Outputs[name].Value)
+	}
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		add("", layer.Name, layer.When)
+		var last *componentUse
+		for j := range layer.Steps {
+			step := &layer.Steps[j]
+			if step.use == nil {
+				stepRefs("", step)
+			} else if step.use != last {
+				last = step.use
+				add("", step.use.step.Name, step.use.step.Tags, step.use.step.With, step.use.step.When)
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.Components) {
+		comp := s.Components[name]
+		for j := range comp.Steps {
+			stepRefs(name, &comp.Steps[j])
+		}
+	}
+	return refs
+}
+
+// Returns the number of references to an input or secret of the stack
+func countReferences(refs []templateRef, root, name string, inComponents bool) int {
+	n := 0
+	for _, ref := range refs {
+		if (ref.component == "" || inComponents) && len(ref.parts) > 1 && ref.parts[0] == root && ref.parts[1] == name {
+			n++
+		}
+	}
+	return n
+}
+
+func lintUnusedInputs(l *linter) {
+	for _, name := range sortedKeys(l.stack.Inputs) {
+		if countReferences(l.refs, "input", name, false) == 0 {
+			l.report(l.stack.Inputs[name].Pos, "input '%s' is never used", name)
+		}
+	}
+	for _, compName := range sortedKeys(l.stack.Components) {
+		comp := l.stack.Components[compName]
+		for _, name := range sortedKeys(comp.Inputs) {
+			used := false
+			for _, ref := range l.refs {
+				used = used || (ref.component == compName && len(ref.parts) > 1 && ref.parts[0] == "input" && ref.parts[1] == name)
+			}
+			if !used {
+				l.report(comp.Inputs[name].Pos, "input '%s' of component '%s' is never used", name, compName)
+			}
+		}
+	}
+}
+
+func lintUnusedSecrets(l *linter) {
+	for _, name := range sortedKeys(l.stack.Secrets) {
+		if countReferences(l.refs, "secret", name, true) == 0 {
+			l.report(l.stack.Secrets[name].Pos, "secret '%s' is never used", name)
+		}
+	}
+}
+
+func lintSingleUseSecrets(l *linter) {
+	for _, name := range sortedKeys(l.stack.Secrets) {
+		if countReferences(l.refs, "secret", name, true) == 1 {
+			l.report(l.stack.Secrets[name].Pos, "secret '%s' is only used once", name)
+		}
+	}
+}
+
+func lintUnusedVariables(l *linter) {
+	s := l.stack
+	// The namespaces each component's variables are registered in
+	namespaces := make(map[string][]string)
+	for _, layer := range s.Layers {
+		for _, step := range layer.Steps {
+			if step.use != nil && !slices.Contains(namespaces[step.use.name], step.use.namespace) {
+				namespaces[step.use.name] = append(namespaces[step.use.name], step.use.namespace)
+			}
+		}
+	}
+	used := make(map[string]bool)
+	markUsed := func(parts []string) {
+		if name, ok := registeredName(s.RegisteredVariables, parts); ok {
+			used[name] = true
+			return
+		}
+		// A reference to a whole namespace uses every variable in it
+		prefix := strings.Join(parts, ".") + "."
+		for name := range s.RegisteredVariables {
+			if strings.HasPrefix(name, prefix) {
+				used[name] = true
+			}
+		}
+	}
+	for _, ref := range l.refs {
+		if len(ref.parts) == 0 {
+			continue
+		}
+		if ref.component == "" {
+			markUsed(ref.parts)
+			continue
+		}
+		for _, ns := range namespaces[ref.component] {
+			markUsed(append([]string{ns}, ref.parts...))
+		}
+	}
+	for _, layer := range s.Layers {
+		for _, step := range layer.Steps {
+			if step.Register == "" || used[step.Register] {
+				continue
+			}
+			pos := step.positions.at("register", step.Pos)
+			if step.use != nil {
+				pos = step.use.step.positions.at("use", step.use.step.Pos)
+			}
+			l.report(pos, "variable '%s' registered by step '%s' is never used", step.Register, step.Name)
+		}
+	}
+}
+
+func lintDuplicateStepNames(l *linter) {
+	check := func(where string, steps []Step) {
+		seen := make(map[string]bool)
+		var last *componentUse
+		for j := range steps {
+			step := &steps[j]
+			if step.use != nil {
+				if step.use == last {
+					continue
+				}
+				last = step.use
+				step = &step.use.step
+			}
+			if step.Name == "" {
+				continue
+			}
+			if seen[step.Name] {
+				l.report(step.positions.at("name", step.Pos), "step name '%s' is used more than once in %s", step.Name, where)
+			}
+			seen[step.Name] = true
+		}
+	}
+	for i := range l.stack.Layers {
+		check(l.stack.Layers[i].describe(), l.stack.Layers[i].Steps)
+	}
+	for _, name := range sortedKeys(l.stack.Components) {
+		check("component '"+name+"'", l.stack.Components[name].Steps)
+	}
+}
+
+var snakeCase = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+
+func lintSnakeCase(l *linter) {
+	s := l.stack
+	check := func(kind, name string, pos Position) {
+		if !snakeCase.MatchString(name) {
+			l.report(pos, "%s '%s' should be named in snake_case", kind, name)
+		}
+	}
+	for _, name := range sortedKeys(s.Inputs) {
+		check("input", name, s.Inputs[name].Pos)
+	}
+	for _, name := range sortedKeys(s.Secrets) {
+		check("secret", name, s.Secrets[name].Pos)
+	}
+	for _, name := range sortedKeys(s.Locals) {
+		check("local", name, s.positions.at("locals."+name, s.Pos))
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		check("output", name, s.Outputs[name].Pos)
+	}
+	for _, name := range sortedKeys(s.Components) {
+		comp := s.Components[name]
+		check("component", name, comp.Pos)
+		for _, input := range sortedKeys(comp.Inputs) {
+			check("input", input, comp.Inputs[input].Pos)
+		}
+		for _, step := range comp.Steps {
+			if step.Register != "" {
+				check("variable", step.Register, step.positions.at("register", step.Pos))
+			}
+		}
+	}
+	for _, layer := range s.Layers {
+		var last *componentUse
+		for _, step := range layer.Steps {
+			switch {
+			case step.use == nil && step.Register != "":
+				check("variable", step.Register, step.positions.at("register", step.Pos))
+			case step.use != nil && step.use != last && step.use.step.Register != "":
+				check("variable", step.use.step.Register, step.use.step.positions.at("register", step.use.step.Pos))
+			}
+			last = step.use
+		}
+	}
+}
+
+func lintMissingDescriptions(l *linter) {
+	s := l.stack
+	if s.Description == "" {
+		l.report(s.Pos, "stack '%s' has no description", s.Name)
+	}
+	for _, name := range sortedKeys(s.Inputs) {
+		if s.Inputs[name].Description == "" {
+			l.report(s.Inputs[name].Pos, "input '%s' has no description", name)
+		}
+	}
+	for _, name := range sortedKeys(s.Secrets) {
+		if s.Secrets[name].Description == "" {
+			l.report(s.Secrets[name].Pos, "secret '%s' has no description", name)
+		}
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		if s.Outputs[name].Description == "" {
+			l.report(s.Outputs[name].Pos, "output '%s' has no description", name)
+		}
+	}
+	for _, name := range sortedKeys(s.Components) {
+		comp := s.Components[name]
+		if comp.Description == "" {
+			l.report(comp.Pos, "component '%s' has no description", name)
+		}
+		for _, input := range sortedKeys(comp.Inputs) {
+			if comp.Inputs[input].Description == "" {
+				l.report(comp.Inputs[input].Pos, "input '%s' of component '%s' has no description", input, name)
+			}
+		}
+	}
+}
+
+func lintMissingLabels(l *linter) {
+	s := l.stack
+	for _, name := range sortedKeys(s.Inputs) {
+		if s.Inputs[name].Label == "" {
+			l.report(s.Inputs[name].Pos, "input '%s' has no label", name)
+		}
+	}
+	for _, name := range sortedKeys(s.Secrets) {
+		if s.Secrets[name].Label == "" {
+			l.report(s.Secrets[name].Pos, "secret '%s' has no label", name)
+		}
+	}
+}
+
+// Address ranges that allow access from anywhere
+var openRanges = []string{"0.0.0.0/0", "::/0"}
+
+func lintOpenIngress(l *linter) {
+	var ports []int
+	for _, port := range l.options["ports"].([]any) {
+		ports = append(ports, port.(int))
+	}
+	check := func(where string, step *Step) {
+		var walk func(val any, path string)
+		walk = func(val any, path string) {
+			switch v := val.(type) {
+			case map[string]any:
+				if port, ok := openPort(v, ports); ok {
+					l.report(step.positions.at(path, step.Pos), "%s allows access from anywhere on port %d", where, port)
+				}
+				for _, key := range sortedKeys(v) {
+					walk(v[key], joinPath(path, key))
+				}
+			case []any:
+				for i, item := range v {
+					walk(item, path+"["+strconv.Itoa(i)+"]")
+				}
+			}
+		}
+		walk(step.Params, "params")
+	}
+	for i := range l.stack.Layers {
+		layer := &l.stack.Layers[i]
+		for j := range layer.Steps {
+			if step := &layer.Steps[j]; step.use == nil {
+				check(fmt.Sprintf("step '%s' in %s", step.Name, layer.describe()), step)
+			}
+		}
+	}
+	for _, name := range sortedKeys(l.stack.Components) {
+		comp := l.stack.Components[name]
+		for j := range comp.Steps {
+			check(fmt.Sprintf("step '%s' in component '%s'", comp.Steps[j].Name, name), &comp.Steps[j])
+		}
+	}
+}
+
+// Returns the first of the ports that a rule, like an ingress rule of a
+// security group, opens to any address. Rules give their addresses in any
+// value and their ports as "port", or "from_port" and "to_port".
+func openPort(rule map[string]any, ports []int) (int, bool) {
+	open := false
+	for _, val := range rule {
+		switch v := val.(type) {
+		case string:
+			open = open || slices.Contains(openRanges, v)
+		case []any:
+			for _, item := range v {
+				open = open || slices.Contains(openRanges, fmt.Sprint(item))
+			}
+		}
+	}
+	if !open {
+		return 0, false
+	}
+	from, to := -1, -1
+	if port, err := toInt(rule["port"]); err == nil {
+		from, to = port, port
+	}
+	if port, err := toInt(rule["from_port"]); err == nil {
+		from = port
+	}
+	if port, err := toInt(rule["to_port"]); err == nil {
+		to = port
+	}
+	if from < 0 && to < 0 {
+		return 0, false
+	}
+	if from < 0 {
+		from = to
+	}
+	if to < 0 {
+		to = from
+	}
+	for _, port := range ports {
+		// Port ranges of 0 to 0, or with -1, usually mean all ports
+		if (port >= from && port <= to) || (from <= 0 && to <= 0) {
+			return port, true
+		}
+	}
+	return 0, false
+}
+
+// lintIgnore is a "# groundctl:ignore" comment, which ignores the problems
+// found by the listed rules, or by all rules if none are listed, on the lines
+// of the element the comment belongs to
+type lintIgnore struct {
+	file     string
+	from, to int
+	rules    []string
+}
+
+// The prefix of comments that ignore lint rules
+const ignoreComment = "groundctl:ignore"
+
+// Reports whether a diagnostic is ignored by a comment
+func (s *Stack) ignored(d Diagnostic) bool {
+	for _, ignore := range s.ignores {
+		if ignore.file == d.Pos.File && d.Pos.Line >= ignore.from && d.Pos.Line <= ignore.to &&
+			(len(ignore.rules) == 0 || slices.Contains(ignore.rules, d.Code)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collects the ignore comments of a document. A comment above or after a
+// mapping entry covers the entry and its value. A comment on the first entry
+// of a list item covers the whole item, and a comment at the top of the file
+// covers the whole file.
+func collectIgnores(file string, doc *yaml.Node) []lintIgnore {
+	var ignores []lintIgnore
+	add := func(from, to int, comments ...string) {
+		for _, comment := range comments {
+			for _, line := range strings.Split(comment, "\n") {
+				line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#"))
+				rest, ok := strings.CutPrefix(line, ignoreComment)
+				if !ok {
+					continue
+				}
+				rules := strings.FieldsFunc(rest, func(r rune) bool { return r == ',' || r == ' ' })
+				ignores = append(ignores, lintIgnore{file: file, from: from, to: to, rules: rules})
+			}
+		}
+	}
+	var walk func(n *yaml.Node, item bool)
+	walk = func(n *yaml.Node, item bool) {
+		add(n.Line, lastLine(n), n.HeadComment, n.LineComment)
+		switch n.Kind {
+		case yaml.DocumentNode:
+			add(1, lastLine(n), n.HeadComment, n.FootComment)
+			for _, c := range n.Content {
+				walk(c, false)
+			}
+		case yaml.SequenceNode:
+			for _, c := range n.Content {
+				walk(c, true)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, val := n.Content[i], n.Content[i+1]
+				from, to := key.Line, lastLine(val)
+				if i == 0 && item {
+					from, to = n.Line, lastLine(n)
+				}
+				add(from, to, key.HeadComment, key.LineComment)
+				if val.Kind == yaml.ScalarNode {
+					add(from, to, val.HeadComment, val.LineComment)
+				} else {
+					walk(val, false)
+				}
+			}
+		}
+	}
+	walk(doc, false)
+	return ignores
+}
+
+// Returns the last line of a node and everything below it
+func lastLine(n *yaml.Node) int {
+	line := n.Line
+	for _, c := range n.Content {
+		line = max(line, lastLine(c))
+	}
+	return line
+}
@@ -0,0 +1,348 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns the diagnostics found by a single rule
+func lintRule(t *testing.T, yamlData, rule string, config stack.LintConfig) stack.Diagnostics {
+	t.Helper()
+	s, err := stack.ParseFile("test.yml", []byte(yamlData))
+	require.NoError(t, err)
+	var found stack.Diagnostics
+	for _, d := range s.Lint(config) {
+		if d.Code == rule {
+			found = append(found, d)
+		}
+	}
+	return found
+}
+
+func lintMessages(diags stack.Diagnostics) []string {
+	messages := make([]string, len(diags))
+	for i, d := range diags {
+		messages[i] = d.Message
+	}
+	return messages
+}
+
+func TestStackLint(t *testing.T) {
+	t.Run("unused inputs and secrets", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+  zone:
+    type: string
+secrets:
+  token:
+    type: string
+  password:
+    type: string
+  api_key:
+    type: string
+layers:
+  - name: main
+    steps:
+      - name: Create
+        aws.thing:
+          region: "{{ $.input.region }}"
+          password: "{{ $.secret.password }}"
+          auth: "{{ $.secret.api_key }}:{{ $.secret.api_key }}"
+`
+		diags := lintRule(t, yamlData, stack.RuleUnusedInput, stack.LintConfig{})
+		assert.Equal(t, []string{"input 'zone' is never used"}, lintMessages(diags))
+		assert.Equal(t, stack.SeverityWarning, diags[0].Severity)
+		assert.Equal(t, 9, diags[0].Pos.Line)
+
+		diags = lintRule(t, yamlData, stack.RuleUnusedSecret, stack.LintConfig{})
+		assert.Equal(t, []string{"secret 'token' is never used"}, lintMessages(diags))
+
+		diags = lintRule(t, yamlData, stack.RuleSingleUseSecret, stack.LintConfig{})
+		assert.Equal(t, []string{"secret 'password' is only used once"}, lintMessages(diags))
+		assert.Equal(t, stack.SeverityInfo, diags[0].Severity)
+	})
+
+	t.Run("unused registered variables", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+components:
+  network:
+    steps:
+      - name: VPC
+        aws.vpc: {}
+        register: vpc
+      - name: Subnet
+        aws.subnet:
+          vpc_id: "{{ $.vpc.id }}"
+        register: subnet
+layers:
+  - name: main
+    steps:
+      - name: Network
+        use: network
+        register: net
+      - name: Bucket
+        aws.bucket: {}
+        register: bucket
+      - name: Instance
+        aws.instance: {}
+        register: instance
+outputs:
+  instance_id:
+    value: "{{ $.instance.id }}"
+`
+		diags := lintRule(t, yamlData, stack.RuleUnusedVariable, stack.LintConfig{})
+		assert.Equal(t, []string{
+			"variable 'net.subnet' registered by step 'Network/Subnet' is never used",
+			"variable 'bucket' registered by step 'Bucket' is never used",
+		}, lintMessages(diags))
+		assert.Equal(t, 20, diags[0].Pos.Line, "component variables are reported at the use")
+	})
+
+	t.Run("namespace references use every component variable", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+components:
+  network:
+    steps:
+      - name: VPC
+        aws.vpc: {}
+        register: vpc
+layers:
+  - name: main
+    steps:
+      - name: Network
+        use: network
+outputs:
+  network:
+    value: "{{ $.network }}"
+`
+		assert.Empty(t, lintRule(t, yamlData, stack.RuleUnusedVariable, stack.LintConfig{}))
+	})
+
+	t.Run("duplicate step names", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: main
+    steps:
+      - name: Create
+        aws.thing: {}
+      - name: Create
+        aws.other: {}
+  - name: other
+    steps:
+      - name: Create
+        aws.thing: {}
+`
+		diags := lintRule(t, yamlData, stack.RuleDuplicateStepName, stack.LintConfig{})
+		assert.Equal(t, []string{"step name 'Create' is used more than once in layer 'main'"}, lintMessages(diags))
+		assert.Equal(t, 11, diags[0].Pos.Line)
+	})
+
+	t.Run("snake case names", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  instanceType:
+    type: string
+locals:
+  Name: test
+layers:
+  - name: main
+    steps:
+      - name: Create
+        aws.thing: {}
+        register: my-thing
+outputs:
+  thing_id:
+    value: "{{ $.my-thing }}"
+`
+		diags := lintRule(t, yamlData, stack.RuleSnakeCase, stack.LintConfig{})
+		assert.Equal(t, []string{
+			"input 'instanceType' should be named in snake_case",
+			"local 'Name' should be named in snake_case",
+			"variable 'my-thing' should be named in snake_case",
+		}, lintMessages(diags))
+	})
+
+	t.Run("missing descriptions and labels", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+description: A test stack
+provider:
+  type: aws
+inputs:
+  region:
+    type: string
+    description: Where to deploy
+  zone:
+    type: string
+    label: Zone
+layers: []
+outputs:
+  id:
+    value: x
+`
+		diags := lintRule(t, yamlData, stack.RuleMissingDescription, stack.LintConfig{})
+		assert.Equal(t, []string{
+			"input 'zone' has no description",
+			"output 'id' has no description",
+		}, lintMessages(diags))
+
+		diags = lintRule(t, yamlData, stack.RuleMissingLabel, stack.LintConfig{})
+		assert.Equal(t, []string{"input 'region' has no label"}, lintMessages(diags))
+	})
+
+	t.Run("open ingress", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+layers:
+  - name: main
+    steps:
+      - name: Security Group
+        aws.security_group:
+          ingress:
+            - cidr_blocks: ["10.0.0.0/8"]
+              from_port: 22
+              to_port: 22
+            - cidr_blocks: ["0.0.0.0/0"]
+              from_port: 443
+              to_port: 443
+            - cidr_blocks: ["0.0.0.0/0"]
+              from_port: 0
+              to_port: 1024
+      - name: RDP
+        aws.security_group_rule:
+          cidr_ipv6: "::/0"
+          port: 3389
+`
+		diags := lintRule(t, yamlData, stack.RuleOpenIngress, stack.LintConfig{})
+		assert.Equal(t, []string{"step 'Security Group' in layer 'main' allows access from anywhere on port 22"}, lintMessages(diags))
+		assert.Equal(t, 18, diags[0].Pos.Line)
+
+		config := stack.LintConfig{Rules: map[string]stack.LintRuleConfig{
+			stack.RuleOpenIngress: {Options: map[string]any{"ports": []any{3389}}},
+		}}
+		diags = lintRule(t, yamlData, stack.RuleOpenIngress, config)
+		assert.Equal(t, []string{"step 'RDP' in layer 'main' allows access from anywhere on port 3389"}, lintMessages(diags))
+	})
+
+	t.Run("configured severities", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  zone:
+    type: string
+layers: []
+`
+		config, err := stack.ParseLintConfig([]byte(`
+rules:
+  unused-input: error
+  missing-label: off
+`))
+		require.NoError(t, err)
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Lint(config)
+		assert.True(t, diags.HasErrors())
+		for _, d := range diags {
+			assert.NotEqual(t, stack.RuleMissingLabel, d.Code)
+			if d.Code == stack.RuleUnusedInput {
+				assert.Equal(t, stack.SeverityError, d.Severity)
+			}
+		}
+	})
+
+	t.Run("ignore comments", func(t *testing.T) {
+		yamlData := `
+version: "1"
+name: test
+provider:
+  type: aws
+inputs:
+  # groundctl:ignore unused-input, snake-case
+  zoneName:
+    type: string
+  region: # groundctl:ignore
+    type: string
+  size:
+    type: string
+layers:
+  - name: main
+    steps:
+      - name: SSH # groundctl:ignore open-ingress
+        aws.security_group_rule:
+          cidr: 0.0.0.0/0
+          port: 22
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Lint(stack.LintConfig{Rules: map[string]stack.LintRuleConfig{
+			stack.RuleMissingDescription: {Severity: "off"},
+			stack.RuleMissingLabel:       {Severity: "off"},
+		}})
+		assert.Equal(t, []string{"input 'size' is never used"}, lintMessages(diags))
+	})
+}
+
+func TestParseLintConfig(t *testing.T) {
+	t.Run("severities and options", func(t *testing.T) {
+		config, err := stack.ParseLintConfig([]byte(`
+rules:
+  missing-label: off
+  open-ingress:
+    severity: error
+    ports: [22, 3389]
+`))
+		require.NoError(t, err)
+		assert.Equal(t, "off", config.Rules["missing-label"].Severity)
+		assert.Equal(t, "error", config.Rules["open-ingress"].Severity)
+		assert.Equal(t, map[string]any{"ports": []any{22, 3389}}, config.Rules["open-ingress"].Options)
+	})
+
+	for name, tc := range map[string]struct {
+		config string
+		err    string
+	}{
+		"unknown rule":     {"rules:\n  no-such-rule: error\n", "unknown rule 'no-such-rule'"},
+		"invalid severity": {"rules:\n  unused-input: fatal\n", "invalid severity 'fatal'"},
+		"unknown option":   {"rules:\n  unused-input:\n    ports: [22]\n", "rule 'unused-input' has no option 'ports'"},
+		"invalid ports":    {"rules:\n  open-ingress:\n    ports: 22\n", "option 'ports' of rule 'open-ingress': expected list(integer), got number 22"},
+		"invalid port":     {"rules:\n  open-ingress:\n    ports: [22, ssh]\n", `option 'ports' of rule 'open-ingress': element 1: expected integer, got string "ssh"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := stack.ParseLintConfig([]byte(tc.config))
+			assert.ErrorIs(t, err, stack.ErrInvalidLintConfig)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrIncludeCycle       = errors.New("include cycle")
+	ErrIncludeOutsideRoot = errors.New("include outside of the stack's root directory")
+)
+
+// The top level keys that can be used in included files
+var includableKeys = []string{"include", "imports", "inputs", "secrets", "layers", "components"}
+
+// Load reads the stack template at the given path from fsys, along with all
+// files it includes. Files listed in "include" or "imports" are read relative
+// to the file that lists them, and can be glob patterns. Included files can
+// only contain inputs, secrets, layers, components and includes of their own.
+// Their inputs, secrets and components are added to the stack, and their layers
+// are added before the layers of the including file, in the order the files are
+// listed. Includes can't refer to files above the root of fsys, like
+// "../common.yml" in a file at the root.
+//
+// Each file is only included once. Positions and errors refer to the file the
+// element or problem came from.
+func Load(fsys fs.FS, name string) (*Stack, error) {
+	l := &loader{fsys: fsys, loaded: make(map[string]bool)}
+	stack, err := l.load(name, Position{})
+	if err != nil {
+		return nil, err
+	}
+	if err := stack.expandComponents(); err != nil {
+		return nil, err
+	}
+	if err := stack.registerVariables(); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// loader reads stack files and their includes from a file system
+type loader struct {
+	fsys fs.FS
+	// Files that were already loaded
+	loaded map[string]bool
+	// Files that are being loaded, each included by the one before
+	loading []string
+}
+
+// Loads a file and merges everything it includes into it. from is where the
+// file was included, or an invalid position for the entry file.
+func (l *loader) load(name string, from Position) (*Stack, error) {
+	logrus.Tracef("Loading stack file %q", name)
+	data, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		if from.IsValid() {
+			return nil, errorAt(from, "failed to read included file: %v", err)
+		}
+		return nil, err
+	}
+	stack, err := decodeFile(name, data)
+	if err != nil {
+		return nil, err
+	}
+	l.loaded[name] = true
+	l.loading = append(l.loading, name)
+	defer func() { l.loading = l.loading[:len(l.loading)-1] }()
+
+	var layers []Layer
+	for _, inc := range stack.includes() {
+		files, err := l.resolve(name, inc)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if i := slices.Index(l.loading, file); i >= 0 {
+				cycle := append(slices.Clone(l.loading[i:]), file)
+				return nil, &PosError{Pos: inc.pos, Err: fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(cycle, " -> "))}
+			}
+			if l.loaded[file] {
+				continue
+			}
+			included, err := l.load(file, inc.pos)
+			if err != nil {
+				return nil, err
+			}
+			if err := included.checkIncludable(); err != nil {
+				return nil, err
+			}
+			if err := stack.merge(included); err != nil {
+				return nil, err
+			}
+			layers = append(layers, included.Layers...)
+		}
+	}
+	stack.Layers = append(layers, stack.Layers...)
+	stack.Include = nil
+	stack.Imports = nil
+	return stack, nil
+}
+
+// Returns the files an include refers to, relative to the including file
+func (l *loader) resolve(from string, inc include) ([]string, error) {
+	name := path.Join(path.Dir(from), inc.path)
+	if !fs.ValidPath(name) {
+		return nil, &PosError{Pos: inc.pos, Err: fmt.Errorf("%w: '%s' is above the directory the stack is loaded from", ErrIncludeOutsideRoot, inc.path)}
+	}
+	if !strings.ContainsAny(inc.path, "*?[") {
+		return []string{name}, nil
+	}
+	files, err := fs.Glob(l.fsys, name)
+	if err != nil {
+		return nil, errorAt(inc.pos, "invalid include pattern '%s': %v", inc.path, err)
+	}
+	if len(files) == 0 {
+		return nil, errorAt(inc.pos, "no files match include pattern '%s'", inc.path)
+	}
+	return files, nil
+}
+
+// include is a file or pattern listed in "include" or "imports"
+type include struct {
+	path string
+	pos  Position
+}
+
+// Returns the includes listed in the stack file
+func (s *Stack) includes() []include {
+	var includes []include
+	lists := []struct {
+		key   string
+		paths []string
+	}{{"include", s.Include}, {"imports", s.Imports}}
+	for _, list := range lists {
+		for i, p := range list.paths {
+			pos := s.positions.at(list.key+"["+strconv.Itoa(i)+"]", s.Pos)
+			includes = append(includes, include{path: p, pos: pos})
+		}
+	}
+	return includes
+}
+
+// Checks that an included file only has keys that can be included
+func (s *Stack) checkIncludable() error {
+	for _, key := range sortedKeys(s.positions) {
+		if strings.ContainsAny(key, ".[") || slices.Contains(includableKeys, key) {
+			continue
+		}
+		return errorAt(s.positions[key], "'%s' can't be set in an included file", key)
+	}
+	return nil
+}
+
+// Adds the inputs, secrets and components of an included file to the stack
+func (s *Stack) merge(included *Stack) error {
+	s.ignores = append(s.ignores, included.ignores...)
+	for _, name := range sortedKeys(included.Inputs) {
+		input := included.Inputs[name]
+		if existing, ok := s.Inputs[name]; ok {
+			return errorAt(input.Pos, "input '%s' is already defined at %s", name, existing.Pos)
+		}
+		if s.Inputs == nil {
+			s.Inputs = make(map[string]Input)
+		}
+		s.Inputs[name] = input
+	}
+	for _, name := range sortedKeys(included.Secrets) {
+		secret := included.Secrets[name]
+		if existing, ok := s.Secrets[name]; ok {
+			return errorAt(secret.Pos, "secret '%s' is already defined at %s", name, existing.Pos)
+		}
+		if s.Secrets == nil {
+			s.Secrets = make(map[string]Secret)
+		}
+		s.Secrets[name] = secret
+	}
+	for _, name := range sortedKeys(included.Components) {
+		comp := included.Components[name]
+		if existing, ok := s.Components[name]; ok {
+			return errorAt(comp.Pos, "component '%s' is already defined at %s", name, existing.Pos)
+		}
+		if s.Components == nil {
+			s.Components = make(map[string]Component)
+		}
+		s.Components[name] = comp
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package stack_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"stacks/main.yml": {Data: []byte(`
+version: "1.0"
+name: web
+provider:
+  type: aws
+include:
+  - ../common/network.yml
+imports:
+  - secrets/*.yml
+layers:
+  - name: compute
+    steps:
+      - name: Launch Instance
+        aws.ec2:
+          subnet_id: "{{ $.my_subnet.id }}"
+`)},
+		"common/network.yml": {Data: []byte(`
+inputs:
+  cidr:
+    type: string
+    default: 10.0.0.0/16
+layers:
+  - name: networking
+    steps:
+      - name: Create Subnet
+        aws.subnet:
+          cidr_block: "{{ $.input.cidr }}"
+        register: my_subnet
+`)},
+		"stacks/secrets/db.yml": {Data: []byte(`
+secrets:
+  db_password:
+    type: string
+`)},
+		"stacks/secrets/api.yml": {Data: []byte(`
+secrets:
+  api_key:
+    type: string
+`)},
+	}
+
+	t.Run("merges included files", func(t *testing.T) {
+		s, err := stack.Load(fsys, "stacks/main.yml")
+		require.NoError(t, err)
+		require.Len(t, s.Layers, 2)
+		assert.Equal(t, "networking", s.Layers[0].Name)
+		assert.Equal(t, "compute", s.Layers[1].Name)
+		assert.Contains(t, s.Inputs, "cidr")
+		assert.Contains(t, s.Secrets, "db_password")
+		assert.Contains(t, s.Secrets, "api_key")
+		assert.Contains(t, s.RegisteredVariables, "my_subnet")
+		assert.Equal(t, stack.Position{File: "common/network.yml", Line: 9, Column: 9}, s.Layers[0].Steps[0].Pos)
+		assert.NoError(t, s.Validate())
+	})
+
+	t.Run("reports errors with the file they came from", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"main.yml":  {Data: []byte("version: \"1.0\"\ninclude: [other.yml]\n")},
+			"other.yml": {Data: []byte("inputs:\n  cidr:\n    type: [string]\n")},
+		}
+		_, err := stack.Load(fsys, "main.yml")
+		assert.ErrorContains(t, err, "other.yml: yaml: unmarshal errors")
+
+		fsys["other.yml"] = &fstest.MapFile{Data: []byte("name: other\n")}
+		_, err = stack.Load(fsys, "main.yml")
+		assert.EqualError(t, err, "other.yml:1:7: 'name' can't be set in an included file")
+
+		fsys["main.yml"] = &fstest.MapFile{Data: []byte("include: [missing.yml]\n")}
+		_, err = stack.Load(fsys, "main.yml")
+		assert.EqualError(t, err, "main.yml:1:11: failed to read included file: open missing.yml: file does not exist")
+	})
+
+	t.Run("detects include cycles", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"main.yml": {Data: []byte("include: [a.yml]\n")},
+			"a.yml":    {Data: []byte("include: [b.yml]\n")},
+			"b.yml":    {Data: []byte("layers: []\ninclude:\n  - a.yml\n")},
+		}
+		_, err := stack.Load(fsys, "main.yml")
+		assert.ErrorIs(t, err, stack.ErrIncludeCycle)
+		assert.EqualError(t, err, "b.yml:3:5: include cycle: a.yml -> b.yml -> a.yml")
+	})
+
+	t.Run("rejects includes above the root", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"stacks/main.yml": {Data: []byte("include: [../../common.yml]\n")},
+			"common.yml":      {Data: []byte("inputs: {}\n")},
+		}
+		_, err := stack.Load(fsys, "stacks/main.yml")
+		assert.ErrorIs(t, err, stack.ErrIncludeOutsideRoot)
+		assert.EqualError(t, err, "stacks/main.yml:1:11: include outside of the stack's root directory: '../../common.yml' is above the directory the stack is loaded from")
+
+		fsys["stacks/main.yml"] = &fstest.MapFile{Data: []byte("include: [../*.yml]\n")}
+		_, err = stack.Load(fsys, "stacks/main.yml")
+		require.NoError(t, err, "patterns can refer to the parent directory inside the root")
+
+		fsys["stacks/main.yml"] = &fstest.MapFile{Data: []byte("include: [../../*.yml]\n")}
+		_, err = stack.Load(fsys, "stacks/main.yml")
+		assert.ErrorIs(t, err, stack.ErrIncludeOutsideRoot)
+	})
+
+	t.Run("includes files once", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"main.yml": {Data: []byte("include: [a.yml, b.yml]\n")},
+			"a.yml":    {Data: []byte("include: [b.yml]\n")},
+			"b.yml":    {Data: []byte("inputs:\n  cidr:\n    type: string\n")},
+		}
+		s, err := stack.Load(fsys, "main.yml")
+		require.NoError(t, err)
+		assert.Len(t, s.Inputs, 1)
+	})
+
+	t.Run("rejects duplicate inputs", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"main.yml": {Data: []byte("include: [a.yml]\ninputs:\n  cidr:\n    type: string\n")},
+			"a.yml":    {Data: []byte("inputs:\n  cidr:\n    type: string\n")},
+		}
+		_, err := stack.Load(fsys, "main.yml")
+		assert.EqualError(t, err, "a.yml:2:3: input 'cidr' is already defined at main.yml:3:3")
+	})
+
+	t.Run("parse doesn't load includes", func(t *testing.T) {
+		_, err := stack.ParseFile("main.yml", []byte("include: [a.yml]\n"))
+		assert.EqualError(t, err, "main.yml:1:11: included files can only be loaded with Load")
+	})
+}
@@ -0,0 +1,115 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var ErrLocalCycle = errors.New("local cycle")
+
+// Returns the names of the locals a value references
+func localReferences(val any) []string {
+	var names []string
+	for _, parts := range templateReferences(val) {
+		if len(parts) < 2 || parts[0] != "local" {
+			continue
+		}
+		if !slices.Contains(names, parts[1]) {
+			names = append(names, parts[1])
+		}
+	}
+	return names
+}
+
+// Returns the names of the locals in an order they can be resolved in, with
+// every local after the locals it references. It fails with ErrLocalCycle if
+// locals reference each other, or themselves.
+func localOrder(locals map[string]any) ([]string, error) {
+	order, cycle := sortLocals(locals)
+	if cycle != nil {
+		return nil, fmt.Errorf("%w: %s", ErrLocalCycle, strings.Join(cycle, " -> "))
+	}
+	return order, nil
+}
+
+// Sorts the locals with a depth-first search. If the locals reference each
+// other in a cycle, it returns the cycle as a path that starts and ends with
+// the same local instead.
+func sortLocals(locals map[string]any) (order, cycle []string) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(locals))
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+		for _, ref := range localReferences(locals[name]) {
+			if _, ok := locals[ref]; !ok {
+				// Undefined locals are reported by Validate
+				continue
+			}
+			switch state[ref] {
+			case visiting:
+				i := slices.Index(path, ref)
+				return append(slices.Clone(path[i:]), ref)
+			case unvisited:
+				if cycle := visit(ref); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, name)
+		return nil
+	}
+	for _, name := range sortedKeys(locals) {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return nil, cycle
+			}
+		}
+	}
+	return order, nil
+}
+
+// Checks the templates of the locals and that they don't reference each
+// other in a cycle. Locals are resolved before any step runs.
+func (s *Stack) validateLocals(diags *Diagnostics, registered map[string]bool) {
+	for _, name := range sortedKeys(s.Locals) {
+		check := templateCheck{
+			context:     fmt.Sprintf("invalid template in local '%s'", name),
+			beforeSteps: true,
+			registered:  registered,
+		}
+		s.checkTemplates(diags, check, s.Locals[name], "locals."+name, s.positions, s.Pos)
+	}
+	if _, cycle := sortLocals(s.Locals); cycle != nil {
+		diags.errorf(s.positions.at("locals."+cycle[0], s.Pos), CodeLocalCycle, "locals reference each other: %s", strings.Join(cycle, " -> "))
+	}
+}
+
+// Resolves the locals in the order of their references. Each local can use
+// the locals resolved before it.
+func (r *resolver) resolveLocals(locals map[string]any) (map[string]any, error) {
+	order, err := localOrder(locals)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[string]any, len(locals))
+	r.vars["local"] = resolved
+	for _, name := range order {
+		val, err := r.resolve(locals[name], "", make(map[string][]Source))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve local '%s': %w", name, err)
+		}
+		resolved[name] = val
+	}
+	return resolved, nil
+}
@@ -2,6 +2,7 @@ package stack
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -9,18 +10,52 @@ import (
 
 var (
 	// All of the reserved variables that cannot be used as registered variable names
-	reservedVariables = []string{"input", "secret"}
+	reservedVariables = []string{"input", "secret", "each", "local"}
+	// All of the reserved step attributes that are not treated as the step action
+	reservedStepKeys = []string{"name", "register", "tags", "when", "for_each", "count", "depends_on", "use", "with"}
 )
 
 // Parse reads in a stack template file and parses into a Stack struct
 func Parse(data []byte) (*Stack, error) {
-	logrus.Tracef("Parsing stack of %d bytes", len(data))
-	stack := Stack{
-		RegisteredVariables: make(map[string]map[string]any),
+	return ParseFile("", data)
+}
+
+// ParseFile parses a stack template like Parse, using filename to report the
+// positions of errors and stack elements. Stacks that include other files must
+// be loaded with Load instead.
+func ParseFile(filename string, data []byte) (*Stack, error) {
+	stack, err := decodeFile(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	if includes := stack.includes(); len(includes) > 0 {
+		return nil, errorAt(includes[0].pos, "included files can only be loaded with Load")
 	}
-	if err := yaml.Unmarshal(data, &stack); err != nil {
+	if err := stack.expandComponents(); err != nil {
 		return nil, err
 	}
+	if err := stack.registerVariables(); err != nil {
+		return nil, err
+	}
+	return stack, nil
+}
+
+// Decodes a single stack file and parses the actions of its steps
+func decodeFile(filename string, data []byte) (*Stack, error) {
+	logrus.Tracef("Parsing stack of %d bytes", len(data))
+	var stack Stack
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fileError(filename, err)
+	}
+	if len(doc.Content) > 0 {
+		root := doc.Content[0]
+		if err := root.Decode(&stack); err != nil {
+			return nil, fileError(filename, err)
+		}
+		stack.annotate(filename, root)
+		stack.ignores = collectIgnores(filename, &doc)
+	}
 	logrus.Tracef("Identified stack %q", stack.Name)
 	logrus.Tracef("Identified provider %q", stack.Provider.Type)
 	logrus.Tracef("Identified %d layers", len(stack.Layers))
@@ -29,32 +64,76 @@ func Parse(data []byte) (*Stack, error) {
 	for i := range stack.Layers {
 		logrus.WithField("layer", stack.Layers[i].Name).Tracef("Parsing layer steps")
 		for j := range stack.Layers[i].Steps {
-			logrus.WithField("step", stack.Layers[i].Steps[j].Name).Tracef("Parsing step")
+			step := &stack.Layers[i].Steps[j]
+			logrus.WithField("step", step.Name).Tracef("Parsing step")
 			// Parse the action names from the steps
-			if err := stack.Layers[i].Steps[j].parseAction(); err != nil {
+			if err := step.parseAction(); err != nil {
 				return nil, err
 			}
-			logrus.WithField("step", stack.Layers[i].Steps[j].Name).Tracef("Found action %q", stack.Layers[i].Steps[j].Action)
-			// Register the variable name as a placeholder in the stack
-			if stack.Layers[i].Steps[j].Register != "" {
-				varName := stack.Layers[i].Steps[j].Register
-				// Check variable name isn't a reserved name
-				for _, reservedVarName := range reservedVariables {
-					if varName == reservedVarName {
-						return nil, fmt.Errorf("'%s' is a reserved variable name", varName)
-					}
+			logrus.WithField("step", step.Name).Tracef("Found action %q", step.Action)
+		}
+	}
+	for name, comp := range stack.Components {
+		for j := range comp.Steps {
+			if err := comp.Steps[j].parseAction(); err != nil {
+				return nil, err
+			}
+		}
+		logrus.WithField("component", name).Tracef("Parsed %d component steps", len(comp.Steps))
+	}
+	return &stack, nil
+}
+
+// Registers the variable names of all steps as placeholders in the stack. The
+// variables of component steps are namespaced, like "network.vpc", and a
+// namespace can't also be the name of a variable.
+func (s *Stack) registerVariables() error {
+	s.RegisteredVariables = make(map[string]map[string]any)
+	for i := range s.Layers {
+		for j := range s.Layers[i].Steps {
+			step := &s.Layers[i].Steps[j]
+			if step.Register == "" {
+				continue
+			}
+			varName := step.Register
+			// Check variable name isn't a reserved name
+			root, _, _ := strings.Cut(varName, ".")
+			for _, reservedVarName := range reservedVariables {
+				if root == reservedVarName {
+					return errorAt(step.positions.at("register", step.Pos), "'%s' is a reserved variable name", varName)
 				}
-				// Check if this variable has already been defined elsewhere
-				if _, ok := stack.RegisteredVariables[varName]; ok {
-					return nil, fmt.Errorf("variable '%s' in step '%s' already defined", varName, stack.Layers[i].Steps[j].Name)
+			}
+			// Check if this variable has already been defined elsewhere
+			if _, ok := s.RegisteredVariables[varName]; ok {
+				return errorAt(step.positions.at("register", step.Pos), "variable '%s' in step '%s' already defined", varName, step.Name)
+			}
+			for existing := range s.RegisteredVariables {
+				if strings.HasPrefix(existing, varName+".") || strings.HasPrefix(varName, existing+".") {
+					return errorAt(step.positions.at("register", step.Pos), "variable '%s' in step '%s' conflicts with variable '%s'", varName, step.Name, existing)
 				}
-				stack.RegisteredVariables[varName] = make(map[string]any)
-				logrus.WithField("step", stack.Layers[i].Steps[j].Name).Tracef("Registers var %q", varName)
 			}
+			s.RegisteredVariables[varName] = make(map[string]any)
+			logrus.WithField("step", step.Name).Tracef("Registers var %q", varName)
 		}
 	}
+	return nil
+}
 
-	return &stack, nil
+// Prefixes YAML decoding errors with the file they came from
+func fileError(filename string, err error) error {
+	if filename == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", filename, err)
+}
+
+func isReservedStepKey(key string) bool {
+	for _, reserved := range reservedStepKeys {
+		if key == reserved {
+			return true
+		}
+	}
+	return false
 }
 
 // Parses the action from the step definition
@@ -65,22 +144,30 @@ func Parse(data []byte) (*Stack, error) {
 //	  name: my-vpc
 //	  cidr_block: 10.0.0.0/16
 //	register: my_vpc
+//
+// Steps that use a component have no action.
 func (t *Step) parseAction() error {
+	if t.Use != "" {
+		for k := range t.Raw {
+			if !isReservedStepKey(k) {
+				return errorAt(t.positions.at("action", t.Pos), "step '%s' can't have an action and use a component", t.Name)
+			}
+		}
+		return nil
+	}
 	for k, v := range t.Raw {
-		switch k {
 		// Ignore all reserved attributes
-		case "name", "register", "tags":
+		if isReservedStepKey(k) {
 			continue
+		}
 		// If not a reserved attribute, assume it's the action and parse
-		default:
-			t.Action = k
-			params, ok := v.(map[string]any)
-			if !ok {
-				return fmt.Errorf("invalid format for step action '%s' in step '%s'", k, t.Name)
-			}
-			t.Params = params
-			return nil
+		t.Action = k
+		params, ok := v.(map[string]any)
+		if !ok {
+			return errorAt(t.positions.at("action", t.Pos), "invalid format for step action '%s' in step '%s'", k, t.Name)
 		}
+		t.Params = params
+		return nil
 	}
-	return fmt.Errorf("no action found in step '%s'", t.Name)
+	return errorAt(t.Pos, "no action found in step '%s'", t.Name)
 }
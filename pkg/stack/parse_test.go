@@ -1,6 +1,7 @@
 package stack_test
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/groundctl/groundctl/pkg/stack"
@@ -47,4 +48,82 @@ layers:
 		_, err := stack.Parse([]byte(yamlData))
 		assert.Error(t, err)
 	})
+
+	t.Run("errors include file positions", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: "dup"
+provider:
+  type: example
+layers:
+  - name: setup
+    steps:
+      - name: first
+        test.action: {}
+        register: thing
+      - name: second
+        test.action: {}
+        register: thing
+`
+		_, err := stack.ParseFile("dup.yml", []byte(yamlData))
+		assert.EqualError(t, err, "dup.yml:14:19: variable 'thing' in step 'second' already defined")
+
+		var posErr *stack.PosError
+		require.ErrorAs(t, err, &posErr)
+		assert.Equal(t, stack.Position{File: "dup.yml", Line: 14, Column: 19}, posErr.Pos)
+
+		_, err = stack.Parse([]byte(strings.ReplaceAll(yamlData, "register: thing", "register: local")))
+		assert.EqualError(t, err, "11:19: 'local' is a reserved variable name")
+	})
+
+	t.Run("steps that use components are expanded", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: "net"
+provider:
+  type: example
+components:
+  network:
+    steps:
+      - name: vpc
+        test.vpc: {}
+        register: vpc
+      - name: subnet
+        test.subnet: {}
+        depends_on: [vpc]
+layers:
+  - name: setup
+    steps:
+      - name: main
+        use: network
+        tags: [net]
+        depends_on: [other]
+      - name: other
+        test.action: {}
+`
+		s, err := stack.ParseFile("net.yml", []byte(yamlData))
+		require.NoError(t, err)
+		steps := s.Layers[0].Steps
+		require.Len(t, steps, 3)
+		assert.Equal(t, "main/vpc", steps[0].Name)
+		assert.Equal(t, "test.vpc", steps[0].Action)
+		assert.Equal(t, "network.vpc", steps[0].Register)
+		assert.Equal(t, []string{"net"}, steps[0].Tags)
+		assert.Equal(t, []string{"main/vpc"}, steps[1].DependsOn)
+		assert.Contains(t, s.RegisteredVariables, "network.vpc")
+
+		g, err := s.Graph()
+		require.NoError(t, err)
+		order, err := g.Order()
+		require.NoError(t, err)
+		require.Len(t, order, 3)
+		assert.Equal(t, "other", order[0].Step.Name)
+
+		_, err = stack.ParseFile("net.yml", []byte(strings.Replace(yamlData, "use: network", "use: networks", 1)))
+		assert.EqualError(t, err, "net.yml:19:14: step 'main' in layer 'setup' uses unknown component 'networks'")
+		_, err = stack.ParseFile("net.yml", []byte(strings.Replace(yamlData, "tags: [net]", "test.action: {}", 1)))
+		assert.EqualError(t, err, "net.yml:20:9: step 'main' can't have an action and use a component")
+		_, err = stack.ParseFile("net.yml", []byte(strings.Replace(yamlData, "      - name: subnet", "      - name: inner\n        use: network\n      - name: subnet", 1)))
+		assert.EqualError(t, err, "net.yml:13:14: step 'inner' in component 'network' can't use another component")
+	})
 }
@@ -0,0 +1,276 @@
+package stack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Position is a location within a stack template file
+type Position struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// IsValid reports whether the position points at a line in a file
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String formats the position as file:line:col, leaving out the file if unknown
+func (p Position) String() string {
+	if !p.IsValid() {
+		return p.File
+	}
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+}
+
+// PosError is an error tied to a position in a stack template file
+type PosError struct {
+	Pos Position
+	Err error
+}
+
+func (e *PosError) Error() string {
+	if pos := e.Pos.String(); pos != "" {
+		return pos + ": " + e.Err.Error()
+	}
+	return e.Err.Error()
+}
+
+func (e *PosError) Unwrap() error {
+	return e.Err
+}
+
+// Returns a new error prefixed with the given position
+func errorAt(pos Position, format string, args ...any) error {
+	return &PosError{Pos: pos, Err: fmt.Errorf(format, args...)}
+}
+
+// positions maps field paths within a stack element to where they were declared.
+// Paths use dots for mapping keys and brackets for sequence indexes, e.g.
+// "params.ingress[0].cidr_blocks".
+type positions map[string]Position
+
+// Looks up the position of a path, falling back to its closest parent path and
+// finally to the given fallback position
+func (p positions) at(path string, fallback Position) Position {
+	for path != "" {
+		if pos, ok := p[path]; ok {
+			return pos
+		}
+		path = parentPath(path)
+	}
+	return fallback
+}
+
+// Records the position of every node below n, prefixing all paths with prefix
+func (p positions) collect(file, prefix string, n *yaml.Node) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			path := joinPath(prefix, key.Value)
+			p.record(file, path, key, val)
+			p.collect(file, path, val)
+		}
+	case yaml.SequenceNode:
+		for i, item := range n.Content {
+			path := prefix + "[" + strconv.Itoa(i) + "]"
+			p[path] = nodePos(file, item)
+			p.collect(file, path, item)
+		}
+	}
+}
+
+// Records a mapping entry. Scalars point at their value, collections at their key.
+func (p positions) record(file, path string, key, val *yaml.Node) {
+	if val.Kind == yaml.ScalarNode || val.Kind == yaml.AliasNode {
+		p[path] = nodePos(file, val)
+	} else {
+		p[path] = nodePos(file, key)
+	}
+}
+
+// Records only the top level entries of a mapping node
+func (p positions) recordKeys(file string, n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		p.record(file, n.Content[i].Value, n.Content[i], n.Content[i+1])
+	}
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndexAny(path, ".["); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func nodePos(file string, n *yaml.Node) Position {
+	return Position{File: file, Line: n.Line, Column: n.Column}
+}
+
+// Returns the key and value nodes of an entry in a mapping node
+func mappingEntry(n *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i], n.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// Attaches the positions from the parsed document to every element of the stack
+func (s *Stack) annotate(file string, root *yaml.Node) {
+	s.Pos = nodePos(file, root)
+	s.positions = make(positions)
+	s.positions.recordKeys(file, root)
+	for _, key := range []string{"include", "imports", "locals"} {
+		if _, n := mappingEntry(root, key); n != nil {
+			s.positions.collect(file, key, n)
+		}
+	}
+
+	if _, n := mappingEntry(root, "provider"); n != nil {
+		s.Provider.Pos = nodePos(file, n)
+		s.Provider.positions = make(positions)
+		s.Provider.positions.collect(file, "", n)
+	}
+
+	if _, n := mappingEntry(root, "inputs"); n != nil {
+		annotateInputs(file, n, s.Inputs)
+	}
+
+	if _, n := mappingEntry(root, "secrets"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			secret, ok := s.Secrets[key.Value]
+			if !ok {
+				continue
+			}
+			secret.Pos = nodePos(file, key)
+			secret.positions = make(positions)
+			secret.positions.collect(file, "", val)
+			s.Secrets[key.Value] = secret
+		}
+	}
+
+	if _, n := mappingEntry(root, "outputs"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			output, ok := s.Outputs[key.Value]
+			if !ok {
+				continue
+			}
+			output.Pos = nodePos(file, key)
+			output.positions = make(positions)
+			output.positions.collect(file, "", val)
+			s.Outputs[key.Value] = output
+		}
+	}
+
+	if _, n := mappingEntry(root, "layers"); n != nil && n.Kind == yaml.SequenceNode {
+		for i, layerNode := range n.Content {
+			if i >= len(s.Layers) {
+				break
+			}
+			layer := &s.Layers[i]
+			layer.Pos = nodePos(file, layerNode)
+			layer.positions = make(positions)
+			layer.positions.recordKeys(file, layerNode)
+			_, stepsNode := mappingEntry(layerNode, "steps")
+			if stepsNode == nil || stepsNode.Kind != yaml.SequenceNode {
+				continue
+			}
+			annotateSteps(file, stepsNode, layer.Steps)
+		}
+	}
+
+	if _, n := mappingEntry(root, "components"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			comp, ok := s.Components[key.Value]
+			if !ok {
+				continue
+			}
+			comp.Pos = nodePos(file, key)
+			comp.positions = make(positions)
+			comp.positions.recordKeys(file, val)
+			if _, inputs := mappingEntry(val, "inputs"); inputs != nil {
+				annotateInputs(file, inputs, comp.Inputs)
+			}
+			if _, steps := mappingEntry(val, "steps"); steps != nil && steps.Kind == yaml.SequenceNode {
+				annotateSteps(file, steps, comp.Steps)
+			}
+			s.Components[key.Value] = comp
+		}
+	}
+}
+
+// Attaches positions to the inputs declared in a mapping node
+func annotateInputs(file string, n *yaml.Node, inputs map[string]Input) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		input, ok := inputs[key.Value]
+		if !ok {
+			continue
+		}
+		input.Pos = nodePos(file, key)
+		input.positions = make(positions)
+		input.positions.collect(file, "", val)
+		inputs[key.Value] = input
+	}
+}
+
+// Attaches positions to the steps declared in a sequence node
+func annotateSteps(file string, n *yaml.Node, steps []Step) {
+	for j, stepNode := range n.Content {
+		if j >= len(steps) {
+			break
+		}
+		steps[j].annotate(file, stepNode)
+	}
+}
+
+// Attaches positions to a step. The action's parameters are recorded under
+// "params" and the action key itself under "action".
+func (t *Step) annotate(file string, n *yaml.Node) {
+	t.Pos = nodePos(file, n)
+	t.positions = make(positions)
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if isReservedStepKey(key.Value) {
+			t.positions.record(file, key.Value, key, val)
+			t.positions.collect(file, key.Value, val)
+			continue
+		}
+		t.positions["action"] = nodePos(file, key)
+		t.positions["params"] = nodePos(file, val)
+		t.positions.collect(file, "params", val)
+	}
+}
@@ -2,73 +2,831 @@ package stack
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
+	"text/template/parse"
 )
 
-// Resolves all references to given inputs and secrets to prepare for a deployment
+var (
+	ErrMissingValue     = errors.New("missing value")
+	ErrInvalidCondition = errors.New("invalid condition")
+	ErrInvalidLoop      = errors.New("invalid loop")
+	ErrInvalidOutput    = errors.New("invalid output")
+)
+
+// ResolveOptions change how templates are resolved
+type ResolveOptions struct {
+	// Lenient renders references to missing values as "<no value>" instead of
+	// failing. Useful for previews, before all values are known.
+	Lenient bool
+}
+
+// Resolves all references to given inputs and secrets to prepare for a deployment.
+// Inputs are prepared with PrepareInputs first. References to values that are
+// missing are errors. Params made of a single template expression keep the type
+// of the value they reference.
+//
+// Locals are resolved before everything else, and can be referenced as
+// $.local.name.
+//
+// Layers and steps with a "when" condition that doesn't hold are skipped. Their
+// params aren't resolved and their registered variables are absent.
+//
+// Steps are resolved in the order of their dependencies, see Graph. Stacks
+// with steps that depend on each other can't be resolved.
+//
+// Steps with for_each or count are expanded into an instance per key, which can
+// reference its key and value as $.each.key and $.each.value. The variable
+// registered by an expanded step is a map of the values registered by each
+// instance, or a list for steps with count.
+//
+// Steps that use a component are resolved as the steps of the component, with
+// the arguments given in "with" as the component's inputs. The component's
+// steps are skipped if the condition of the using step doesn't hold.
+//
+// Resolve replaces the provider properties and step params of the stack with
+// their resolved values, and expanded steps with their instances. Use
+// ResolveStack to keep the stack untouched.
 func (s *Stack) Resolve(inputs map[string]any, secrets map[string]string) error {
-	ctx := map[string]any{
+	return s.ResolveWithOptions(inputs, secrets, ResolveOptions{})
+}
+
+// ResolveWithOptions resolves the stack like Resolve, using the given options
+func (s *Stack) ResolveWithOptions(inputs map[string]any, secrets map[string]string, opts ResolveOptions) error {
+	resolved, err := s.ResolveStack(inputs, secrets, opts)
+	if err != nil {
+		return err
+	}
+	s.Provider.Properties = resolved.Provider.Properties
+	for i := range s.Layers {
+		steps := make([]Step, 0, len(resolved.Layers[i].Steps))
+		for _, resolvedStep := range resolved.Layers[i].Steps {
+			step := s.Layers[i].Steps[resolvedStep.Index]
+			if resolvedStep.Key != nil {
+				step.Name = resolvedStep.Name
+				step.ForEach = nil
+				step.Count = nil
+			}
+			if !resolvedStep.Skipped {
+				step.Params = resolvedStep.Params
+			}
+			steps = append(steps, step)
+		}
+		s.Layers[i].Steps = steps
+	}
+	return nil
+}
+
+// ResolveStack resolves the stack like Resolve, but returns the resolved values
+// as a separate ResolvedStack and leaves the stack itself untouched. The same
+// stack can be resolved any number of times with different values.
+func (s *Stack) ResolveStack(inputs map[string]any, secrets map[string]string, opts ResolveOptions) (*ResolvedStack, error) {
+	resolved, _, err := s.resolveStack(inputs, secrets, opts)
+	return resolved, err
+}
+
+// ResolveOutputs resolves the stack like ResolveStack and returns the values of
+// its outputs. Outputs are resolved after all steps, so they can reference the
+// variables registered by every step that ran. Call it once RegisteredVariables
+// holds the values registered by the steps. Outputs with a type are converted
+// to it. Outputs derived from secrets are sensitive, even if they aren't marked
+// as such.
+func (s *Stack) ResolveOutputs(inputs map[string]any, secrets map[string]string, opts ResolveOptions) (map[string]ResolvedOutput, error) {
+	_, r, err := s.resolveStack(inputs, secrets, opts)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]ResolvedOutput, len(s.Outputs))
+	for _, name := range sortedKeys(s.Outputs) {
+		output := s.Outputs[name]
+		resolvedOutput := ResolvedOutput{
+			Description: output.Description,
+			Type:        output.Type,
+			Sensitive:   output.Sensitive,
+			Sources:     make(map[string][]Source),
+		}
+		val, err := r.resolve(output.Value, "", resolvedOutput.Sources)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve output '%s': %w", name, err)
+		}
+		if output.Type != "" && !r.unknown(val) {
+			t, err := ParseType(output.Type)
+			if err != nil {
+				return nil, fmt.Errorf("output '%s' has an %w", name, err)
+			}
+			if val, err = t.Convert(val); err != nil {
+				return nil, fmt.Errorf("%w: output '%s' is not a valid %s: %v", ErrInvalidOutput, name, t, err)
+			}
+		}
+		resolvedOutput.Value = val
+		resolvedOutput.Sensitive = output.Sensitive || len(r.sensitivePaths(resolvedOutput.Sources)) > 0
+		outputs[name] = resolvedOutput
+	}
+	return outputs, nil
+}
+
+// Resolves the stack, returning the resolver with the context the steps left
+// behind
+func (s *Stack) resolveStack(inputs map[string]any, secrets map[string]string, opts ResolveOptions) (*ResolvedStack, *resolver, error) {
+	inputs, err := s.PrepareInputs(inputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid inputs: %w", err)
+	}
+	vars := map[string]any{
 		"input":  inputs,
 		"secret": secrets,
 	}
+	for name, registered := range s.RegisteredVariables {
+		setPath(vars, name, registered)
+	}
+	r := &resolver{ctx: vars, vars: vars, uses: make(map[*componentUse]*useContext), opts: opts}
+	r.sensitiveLocals = make(map[string]bool)
+	for name, val := range s.Locals {
+		r.sensitiveLocals[name] = len(s.secretsIn(val)) > 0
+	}
+	locals, err := r.resolveLocals(s.Locals)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolved := &ResolvedStack{
+		Inputs:          inputs,
+		Locals:          locals,
+		SensitiveLocals: r.sensitiveLocals,
+		Provider: ResolvedProvider{
+			Type:    s.Provider.Type,
+			Sources: make(map[string][]Source),
+		},
+	}
+	providerProps, err := r.resolve(s.Provider.Properties, "", resolved.Provider.Sources)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve provider properties: %w", err)
+	}
+	resolved.Provider.Properties = providerProps.(map[string]any)
+	resolved.Provider.Sensitive = r.sensitivePaths(resolved.Provider.Sources)
+
+	// Steps are resolved in the order of their dependencies, so that variables
+	// of skipped and expanded steps are known before they are used
+	g, err := s.Graph()
+	if err != nil {
+		return nil, nil, err
+	}
+	order, err := g.Order()
+	if err != nil {
+		return nil, nil, err
+	}
+	steps := make([][][]ResolvedStep, len(s.Layers))
+	evaluated := make([]bool, len(s.Layers))
+	resolved.Layers = make([]ResolvedLayer, len(s.Layers))
+	for i, layer := range s.Layers {
+		resolved.Layers[i] = ResolvedLayer{Name: layer.Name, When: layer.When}
+		steps[i] = make([][]ResolvedStep, len(layer.Steps))
+	}
+	// Layer conditions are evaluated right before their first step
+	evalLayer := func(i int) error {
+		if evaluated[i] {
+			return nil
+		}
+		evaluated[i] = true
+		run, err := r.condition(s.Layers[i].When)
+		if err != nil {
+			return fmt.Errorf("failed to resolve condition of layer '%s': %w", s.Layers[i].Name, err)
+		}
+		resolved.Layers[i].Skipped = !run
+		return nil
+	}
+	for _, n := range order {
+		if err := evalLayer(n.LayerIndex); err != nil {
+			return nil, nil, err
+		}
+		run := !resolved.Layers[n.LayerIndex].Skipped
+		if steps[n.LayerIndex][n.StepIndex], err = r.resolveStep(s, n.Layer, n.StepIndex, run); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := range resolved.Layers {
+		if err := evalLayer(i); err != nil {
+			return nil, nil, err
+		}
+		for _, instances := range steps[i] {
+			resolved.Layers[i].Steps = append(resolved.Layers[i].Steps, instances...)
+		}
+	}
+
+	return resolved, r, nil
+}
+
+// Resolves a step, or each instance of a step expanded with for_each or count.
+// The step is skipped if run is false or its condition doesn't hold. The steps
+// of a component are resolved in the context of the component.
+func (r *resolver) resolveStep(s *Stack, layer *Layer, index int, run bool) ([]ResolvedStep, error) {
+	step := &layer.Steps[index]
+	r.ctx = r.vars
+	r.sensitiveInputs = nil
+	defer func() { r.ctx, r.sensitiveInputs = r.vars, nil }()
+	if step.use != nil && run {
+		var err error
+		if r.ctx, run, err = r.componentContext(s, step.use, layer); err != nil {
+			return nil, err
+		}
+	}
+	// Steps of a skipped layer aren't expanded
+	instances := []stepInstance{{}}
+	expanded := run && (step.ForEach != nil || step.Count != nil)
+	if expanded {
+		var err error
+		if instances, err = r.instances(step); err != nil {
+			return nil, fmt.Errorf("failed to expand step '%s' in layer '%s': %w", step.Name, layer.Name, err)
+		}
+	}
+	steps := make([]ResolvedStep, 0, len(instances))
+	// Registered variables of expanded steps are maps or lists of the values
+	// registered by each instance, keyed by the instance key
+	var registeredMap map[string]any
+	var registeredList []any
+	if expanded && step.Count != nil {
+		registeredList = make([]any, len(instances))
+	} else if expanded {
+		registeredMap = make(map[string]any, len(instances))
+	}
 
-	var tmplEval func(val any) (any, error)
-	tmplEval = func(val any) (any, error) {
+	for i, inst := range instances {
+		resolvedStep := ResolvedStep{
+			Name:     step.Name,
+			Index:    index,
+			Action:   step.Action,
+			Register: step.Register,
+			Tags:     step.Tags,
+			When:     step.When,
+			Sources:  make(map[string][]Source),
+		}
+		if expanded {
+			resolvedStep.Name = fmt.Sprintf("%s[%v]", step.Name, inst.key)
+			resolvedStep.Key = inst.key
+			r.ctx["each"] = map[string]any{"key": inst.key, "value": inst.value}
+		}
+		// Steps of a skipped layer are skipped without checking their condition
+		runStep := run
+		if run {
+			var err error
+			if runStep, err = r.condition(step.When); err != nil {
+				return nil, fmt.Errorf("failed to resolve condition of step '%s' in layer '%s': %w", resolvedStep.Name, layer.Name, err)
+			}
+		}
+		if !runStep {
+			resolvedStep.Skipped = true
+			steps = append(steps, resolvedStep)
+			continue
+		}
+		params, err := r.resolve(step.Params, "", resolvedStep.Sources)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve step '%s' in layer '%s': %w", resolvedStep.Name, layer.Name, err)
+		}
+		resolvedStep.Params = params.(map[string]any)
+		resolvedStep.Sensitive = r.sensitivePaths(resolvedStep.Sources)
+		steps = append(steps, resolvedStep)
+
+		if expanded && step.Register != "" {
+			key := fmt.Sprint(inst.key)
+			if val, ok := s.RegisteredVariables[step.Register][key]; ok {
+				if registeredList != nil {
+					registeredList[i] = val
+				} else {
+					registeredMap[key] = val
+				}
+			}
+		}
+	}
+	delete(r.ctx, "each")
+
+	// Later steps can't use the variable of a step that didn't run
+	if step.Register != "" {
+		switch {
+		case !expanded && steps[0].Skipped:
+			deletePath(r.vars, step.Register)
+		case registeredList != nil:
+			setPath(r.vars, step.Register, registeredList)
+		case registeredMap != nil:
+			setPath(r.vars, step.Register, registeredMap)
+		}
+	}
+	return steps, nil
+}
+
+// stepInstance is one instance of a step expanded with for_each or count
+type stepInstance struct {
+	key   any
+	value any
+}
+
+// Returns the instances of a step expanded with for_each or count. Steps with
+// for_each have an instance per element of a list, keyed by the element, or per
+// entry of a map, keyed by the map key. Steps with count have count instances,
+// keyed by their index.
+func (r *resolver) instances(step *Step) ([]stepInstance, error) {
+	switch {
+	case step.ForEach != nil:
+		val, err := r.resolve(step.ForEach, "for_each", make(map[string][]Source))
+		if err != nil {
+			return nil, err
+		}
+		var instances []stepInstance
 		switch v := val.(type) {
-		case string:
-			if !strings.Contains(v, "{{") {
-				return v, nil
+		case []any:
+			seen := make(map[string]bool, len(v))
+			for _, elem := range v {
+				key := fmt.Sprint(elem)
+				if seen[key] {
+					return nil, fmt.Errorf("%w: for_each has duplicate key '%s'", ErrInvalidLoop, key)
+				}
+				seen[key] = true
+				instances = append(instances, stepInstance{key: key, value: elem})
+			}
+		case map[string]any:
+			for _, key := range sortedKeys(v) {
+				instances = append(instances, stepInstance{key: key, value: v[key]})
+			}
+		default:
+			// Collections that aren't known yet have no instances when
+			// resolving leniently
+			if r.unknown(val) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: for_each must be a list or map, got %v", ErrInvalidLoop, val)
+		}
+		return instances, nil
+	case step.Count != nil:
+		val, err := r.resolve(step.Count, "count", make(map[string][]Source))
+		if err != nil {
+			return nil, err
+		}
+		n, err := toInt(val)
+		if err != nil || n < 0 {
+			if r.unknown(val) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: count must be a whole number of at least 0, got %v", ErrInvalidLoop, val)
+		}
+		instances := make([]stepInstance, n)
+		for i := range instances {
+			instances[i] = stepInstance{key: i, value: i}
+		}
+		return instances, nil
+	}
+	return nil, nil
+}
+
+// useContext is what the steps of a component see of its use
+type useContext struct {
+	// Whether the condition of the using step holds
+	run bool
+	// The values of the component's inputs, and which of them are derived
+	// from secrets
+	inputs    map[string]any
+	sensitive map[string]bool
+}
+
+// Returns the context the steps of a component are resolved in, or false if
+// the condition of the step using the component doesn't hold. The condition
+// and arguments of the using step are resolved once, outside of the component.
+// Within the component, its inputs are $.input and the variables registered by
+// its steps have their names without the namespace of the use.
+func (r *resolver) componentContext(s *Stack, use *componentUse, layer *Layer) (map[string]any, bool, error) {
+	c, ok := r.uses[use]
+	if !ok {
+		c = &useContext{}
+		r.uses[use] = c
+		run, err := r.condition(use.step.When)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to resolve condition of step '%s' in layer '%s': %w", use.step.Name, layer.Name, err)
+		}
+		if run {
+			with, err := r.resolve(use.step.With, "with", make(map[string][]Source))
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to resolve step '%s' in layer '%s': %w", use.step.Name, layer.Name, err)
+			}
+			args, _ := with.(map[string]any)
+			inputs, err := (&Stack{Inputs: use.component.Inputs}).PrepareInputs(args)
+			if err != nil {
+				// Arguments that aren't known yet are passed on as they are
+				// when resolving leniently
+				if !r.opts.Lenient {
+					return nil, false, fmt.Errorf("invalid inputs of component '%s' in step '%s' in layer '%s': %w", use.name, use.step.Name, layer.Name, err)
+				}
+				inputs = args
+			}
+			c.run = true
+			c.inputs = inputs
+			c.sensitive = make(map[string]bool)
+			for name, val := range use.step.With {
+				c.sensitive[name] = len(s.secretsIn(val)) > 0
 			}
-			tmpl, err := template.New("").Parse(v)
+		}
+	}
+	if !c.run {
+		return nil, false, nil
+	}
+	r.sensitiveInputs = c.sensitive
+	ctx := map[string]any{
+		"input":  c.inputs,
+		"secret": r.vars["secret"],
+		"local":  r.vars["local"],
+	}
+	if registered, ok := getPath(r.vars, use.namespace).(map[string]any); ok {
+		for name, val := range registered {
+			ctx[name] = val
+		}
+	}
+	return ctx, true, nil
+}
+
+// resolver evaluates the templates in a tree of values against a context
+type resolver struct {
+	// The context templates are evaluated in, which is either vars or the
+	// context of a component
+	ctx map[string]any
+	// The inputs, secrets and registered variables of the stack
+	vars map[string]any
+	// The contexts of the components used by the stack
+	uses map[*componentUse]*useContext
+	// The locals derived from secrets, and the inputs of the component being
+	// resolved that are
+	sensitiveLocals map[string]bool
+	sensitiveInputs map[string]bool
+	opts            ResolveOptions
+}
+
+// Reports whether a value is missing because it isn't known yet, which is only
+// the case when resolving leniently
+func (r *resolver) unknown(val any) bool {
+	return r.opts.Lenient && (val == nil || strings.Contains(fmt.Sprint(val), "<no value>"))
+}
+
+// Returns a copy of val with all templates evaluated. The sources of every
+// value are recorded in sources, keyed by path.
+func (r *resolver) resolve(val any, path string, sources map[string][]Source) (any, error) {
+	switch v := val.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			sources[path] = []Source{{Kind: SourceLiteral}}
+			return v, nil
+		}
+		res, err := evalTemplate(v, r.ctx, r.opts)
+		if err != nil && path == "" {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("param '%s': %w", path, err)
+		}
+		sources[path] = templateSources(v)
+		return res, nil
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			res, err := r.resolve(val, joinPath(path, k), sources)
 			if err != nil {
 				return nil, err
 			}
-			var buf bytes.Buffer
-			if err := tmpl.Execute(&buf, ctx); err != nil {
+			out[k] = res
+		}
+		return out, nil
+	case []any:
+		out := make([]any, len(v))
+		for i := range v {
+			res, err := r.resolve(v[i], path+"["+strconv.Itoa(i)+"]", sources)
+			if err != nil {
 				return nil, err
 			}
-			return buf.String(), nil
+			out[i] = res
+		}
+		return out, nil
+	default:
+		sources[path] = []Source{{Kind: SourceLiteral}}
+		return v, nil
+	}
+}
+
+// Evaluates a "when" condition. Conditions must evaluate to a bool, or to a
+// string that parses as one, like the output of "{{ if .x }}true{{ end }}". An
+// empty condition always holds, and so do conditions that can't be evaluated
+// yet when resolving leniently.
+func (r *resolver) condition(when string) (bool, error) {
+	if when == "" {
+		return true, nil
+	}
+	val, err := evalTemplate(when, r.ctx, r.opts)
+	if err != nil {
+		if r.opts.Lenient {
+			return true, nil
+		}
+		return false, err
+	}
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return false, nil
+		}
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b, nil
+		}
+		if r.unknown(v) {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("%w: expected a bool, got %v", ErrInvalidCondition, val)
+}
+
+// Name of the template function used to capture the value of an expression
+const captureFunc = "_capture"
+
+// Evaluates a template string. Unless resolving leniently, every variable the
+// template references must exist in ctx. A template made of a single expression,
+// like "{{ $.input.replicas }}", evaluates to the value with its original type.
+// Anything else renders to a string.
+func evalTemplate(tmplStr string, ctx map[string]any, opts ResolveOptions) (any, error) {
+	val, err := execTemplate(tmplStr, ctx, opts)
+	if key, ok := missingKey(err); ok {
+		return nil, fmt.Errorf("%w for %s", ErrMissingValue, missingReference(tmplStr, ctx, key))
+	}
+	return val, err
+}
+
+// Matches the error text/template fails with when a map has no entry for a key
+var missingKeyError = regexp.MustCompile(`map has no entry for key "([^"]*)"`)
+
+// Returns the key of the error a template fails with when a map has no entry
+// for it
+func missingKey(err error) (string, bool) {
+	var execErr template.ExecError
+	if !errors.As(err, &execErr) {
+		return "", false
+	}
+	m := missingKeyError.FindStringSubmatch(execErr.Error())
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// Names the reference of a template that failed because a map has no entry
+// for key, like "reference '$.vpc.id'", or names the key if no reference
+// is missing it
+func missingReference(tmplStr string, ctx map[string]any, key string) string {
+	if varPaths, err := ExtractVariablePaths(tmplStr); err == nil {
+		for _, parts := range varPaths {
+			if slices.Contains(parts, key) && !hasPath(ctx, parts) {
+				return "reference '$." + strings.Join(parts, ".") + "'"
+			}
+		}
+	}
+	return "key '" + key + "'"
+}
+
+func execTemplate(tmplStr string, ctx map[string]any, opts ResolveOptions) (any, error) {
+	tmpl, err := newTemplate(opts).Parse(tmplStr)
+	if err != nil {
+		return nil, err
+	}
+	allowMissing(tmpl.Tree)
+
+	if pipe := singleExpression(tmpl); pipe != nil {
+		var captured any
+		capture := newTemplate(opts).Funcs(template.FuncMap{
+			captureFunc: func(val any) string {
+				captured = val
+				return ""
+			},
+		})
+		if _, err := capture.Parse("{{ " + pipe.String() + " | " + captureFunc + " }}"); err != nil {
+			return nil, err
+		}
+		if err := capture.Execute(io.Discard, ctx); err != nil {
+			return nil, err
+		}
+		// Missing values are rendered as usual when resolving leniently
+		if captured != nil || !opts.Lenient {
+			return captured, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return nil, err
+	}
+	return buf.String(), nil
+}
+
+func newTemplate(opts ResolveOptions) *template.Template {
+	tmpl := template.New("").Funcs(templateFuncs).Funcs(template.FuncMap{lookupFunc: lookupValue})
+	if !opts.Lenient {
+		tmpl = tmpl.Option("missingkey=error")
+	}
+	return tmpl
+}
+
+// Name of the template function used to look up values that may be missing
+const lookupFunc = "_lookup"
+
+// Returns the value at the keys of val, or nil if there is none
+func lookupValue(val any, keys ...string) any {
+	for _, key := range keys {
+		switch m := val.(type) {
 		case map[string]any:
-			out := make(map[string]any)
-			for k, val := range v {
-				res, err := tmplEval(val)
-				if err != nil {
-					return nil, err
-				}
-				out[k] = res
+			val = m[key]
+		case map[string]string:
+			val = m[key]
+		default:
+			return nil
+		}
+	}
+	return val
+}
+
+// Index of the first argument of the functions that accept missing values,
+// counting the function itself
+var optionalArgs = map[string]int{"default": 2, "coalesce": 1, "required": 2}
+
+// Rewrites the references given to functions that accept missing values, like
+// default, into lookups that result in nil instead of failing on a missing key
+func allowMissing(tree *parse.Tree) {
+	if tree == nil {
+		return
+	}
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
 			}
-			return out, nil
-		case []any:
-			for i := range v {
-				res, err := tmplEval(v[i])
-				if err != nil {
-					return nil, err
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for i, cmd := range n.Cmds {
+				for _, arg := range cmd.Args {
+					walk(arg)
+				}
+				ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+				if !ok {
+					continue
+				}
+				first, ok := optionalArgs[ident.Ident]
+				if !ok {
+					continue
+				}
+				for j := first; j < len(cmd.Args); j++ {
+					if args := lookupArgs(cmd.Args[j]); args != nil {
+						cmd.Args[j] = &parse.PipeNode{NodeType: parse.NodePipe, Pos: cmd.Args[j].Position(), Cmds: []*parse.CommandNode{
+							{NodeType: parse.NodeCommand, Pos: cmd.Args[j].Position(), Args: args},
+						}}
+					}
+				}
+				// The value piped into the function
+				if i > 0 && len(n.Cmds[i-1].Args) == 1 {
+					if args := lookupArgs(n.Cmds[i-1].Args[0]); args != nil {
+						n.Cmds[i-1].Args = args
+					}
 				}
-				v[i] = res
 			}
-			return v, nil
-		default:
-			return v, nil
 		}
 	}
+	walk(tree.Root)
+}
 
-	providerProps, err := tmplEval(s.Provider.Properties)
-	if err != nil {
-		return fmt.Errorf("failed to resolve provider properties: %w", err)
+// Returns the arguments of a lookup of a field reference, like
+// _lookup $ "vpc" "id" for $.vpc.id, or nil if the node isn't one
+func lookupArgs(node parse.Node) []parse.Node {
+	var root parse.Node
+	var keys []string
+	switch n := node.(type) {
+	case *parse.VariableNode:
+		if len(n.Ident) < 2 {
+			return nil
+		}
+		root = &parse.VariableNode{NodeType: parse.NodeVariable, Pos: n.Pos, Ident: n.Ident[:1]}
+		keys = n.Ident[1:]
+	case *parse.FieldNode:
+		root = &parse.DotNode{NodeType: parse.NodeDot, Pos: n.Pos}
+		keys = n.Ident
+	case *parse.ChainNode:
+		root = n.Node
+		keys = n.Field
+	default:
+		return nil
+	}
+	args := []parse.Node{parse.NewIdentifier(lookupFunc).SetPos(node.Position())}
+	args = append(args, root)
+	for _, key := range keys {
+		args = append(args, &parse.StringNode{NodeType: parse.NodeString, Pos: node.Position(), Quoted: strconv.Quote(key), Text: key})
 	}
-	s.Provider.Properties = providerProps.(map[string]any)
+	return args
+}
 
-	for i := range s.Layers {
-		for j := range s.Layers[i].Steps {
-			params, err := tmplEval(s.Layers[i].Steps[j].Params)
-			if err != nil {
-				return fmt.Errorf("failed to resolve step '%s' in layer '%s': %w", s.Layers[i].Steps[j].Name, s.Layers[i].Name, err)
-			}
-			s.Layers[i].Steps[j].Params = params.(map[string]any)
+// Returns the pipeline of a template that consists of nothing but one
+// expression, or nil if the template has any other content
+func singleExpression(tmpl *template.Template) *parse.PipeNode {
+	if tmpl.Tree == nil || len(tmpl.Tree.Root.Nodes) != 1 {
+		return nil
+	}
+	action, ok := tmpl.Tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) > 0 {
+		return nil
+	}
+	return action.Pipe
+}
+
+// Sets the value at a dotted path, like "network.vpc", adding maps for the
+// parents of the value as needed
+func setPath(ctx map[string]any, path string, val any) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := ctx[part].(map[string]any)
+		if !ok {
+			next = make(map[string]any)
+			ctx[part] = next
 		}
+		ctx = next
 	}
+	ctx[parts[len(parts)-1]] = val
+}
 
-	return nil
+// Returns the value at a dotted path, or nil if there is none
+func getPath(ctx map[string]any, path string) any {
+	var cur any = ctx
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = m[part]
+	}
+	return cur
+}
+
+// Deletes the value at a dotted path
+func deletePath(ctx map[string]any, path string) {
+	parent, name := "", path
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		parent, name = path[:i], path[i+1:]
+	}
+	if parent == "" {
+		delete(ctx, name)
+	} else if m, ok := getPath(ctx, parent).(map[string]any); ok {
+		delete(m, name)
+	}
+}
+
+// Reports whether a variable path can be looked up in ctx
+func hasPath(ctx map[string]any, parts []string) bool {
+	var cur any = ctx
+	for _, part := range parts {
+		switch m := cur.(type) {
+		case map[string]any:
+			val, ok := m[part]
+			if !ok {
+				return false
+			}
+			cur = val
+		case map[string]string:
+			val, ok := m[part]
+			if !ok {
+				return false
+			}
+			cur = val
+		default:
+			// Field access on other values is left to the template engine
+			return true
+		}
+	}
+	return true
 }
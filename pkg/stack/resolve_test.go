@@ -0,0 +1,625 @@
+package stack_test
+
+import (
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const resolveStack = `
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    region: "{{ $.input.region }}"
+inputs:
+  region:
+    type: string
+    default: us-east-1
+layers:
+  - name: networking
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "vpc-{{ $.input.region }}"
+        register: my_vpc
+      - name: Create Subnet
+        aws.subnet:
+          vpc_id: "{{ $.my_vpc.id }}"
+`
+
+func TestStackResolve(t *testing.T) {
+	t.Run("fails on missing values", func(t *testing.T) {
+		s, err := stack.Parse([]byte(resolveStack))
+		require.NoError(t, err)
+		err = s.Resolve(nil, nil)
+		assert.ErrorIs(t, err, stack.ErrMissingValue)
+		assert.EqualError(t, err, "failed to resolve step 'Create Subnet' in layer 'networking': param 'vpc_id': missing value for reference '$.my_vpc.id'")
+	})
+
+	t.Run("missing values in untaken branches and defaults", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  env:
+    type: string
+    default: dev
+  tag:
+    type: string
+    default: ""
+layers:
+  - name: network
+    steps:
+      - name: Create bastion
+        when: "{{ eq $.input.env \"prod\" }}"
+        aws.ec2_instance:
+          name: bastion
+        register: bastion
+      - name: Record
+        aws.route53_record:
+          if: '{{ if eq $.input.env "prod" }}{{ $.bastion.ip }}{{ end }}'
+          with: "{{ with $.input.tag }}{{ $.bastion.ip }}{{ else }}none{{ end }}"
+          piped: '{{ $.bastion.ip | default "none" }}'
+          called: '{{ default "none" $.bastion.ip }}'
+          coalesce: "{{ coalesce $.bastion.ip $.input.env }}"
+`))
+		require.NoError(t, err)
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{
+			"if":       "",
+			"with":     "none",
+			"piped":    "none",
+			"called":   "none",
+			"coalesce": "dev",
+		}, resolved.Layers[0].Steps[1].Params)
+
+		s.Layers[0].Steps[1].Params["piped"] = `{{ $.bastion.ip | upper | default "none" }}`
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrMissingValue)
+		assert.EqualError(t, err, "failed to resolve step 'Record' in layer 'network': param 'piped': missing value for reference '$.bastion.ip'")
+
+		s.Layers[0].Steps[1].Params["piped"] = "{{ with $.input.env }}{{ $.bastion.ip }}{{ end }}"
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.EqualError(t, err, "failed to resolve step 'Record' in layer 'network': param 'piped': missing value for reference '$.bastion.ip'")
+	})
+
+	t.Run("resolves registered variables", func(t *testing.T) {
+		s, err := stack.Parse([]byte(resolveStack))
+		require.NoError(t, err)
+		s.RegisteredVariables["my_vpc"]["id"] = "vpc-123"
+		require.NoError(t, s.Resolve(map[string]any{"region": "eu-west-1"}, nil))
+		assert.Equal(t, map[string]any{"region": "eu-west-1"}, s.Provider.Properties)
+		assert.Equal(t, "vpc-eu-west-1", s.Layers[0].Steps[0].Params["name"])
+		assert.Equal(t, "vpc-123", s.Layers[0].Steps[1].Params["vpc_id"])
+	})
+
+	t.Run("lenient mode", func(t *testing.T) {
+		s, err := stack.Parse([]byte(resolveStack))
+		require.NoError(t, err)
+		require.NoError(t, s.ResolveWithOptions(nil, nil, stack.ResolveOptions{Lenient: true}))
+		assert.Equal(t, "<no value>", s.Layers[0].Steps[1].Params["vpc_id"])
+	})
+
+	t.Run("single expressions keep their type", func(t *testing.T) {
+		s := &stack.Stack{
+			Inputs: map[string]stack.Input{
+				"replicas": {Type: "integer", Default: 3},
+				"sgs":      {Type: "list(string)", Default: []any{"a", "b"}},
+				"public":   {Type: "bool", Default: true},
+			},
+			Layers: []stack.Layer{{
+				Name: "compute",
+				Steps: []stack.Step{{
+					Name:   "Launch",
+					Action: "aws.ec2",
+					Params: map[string]any{
+						"count":           "{{ $.input.replicas }}",
+						"security_groups": "{{ $.input.sgs }}",
+						"public":          "{{- $.input.public -}}",
+						"name":            "web-{{ $.input.replicas }}",
+					},
+				}},
+			}},
+		}
+		require.NoError(t, s.Resolve(nil, nil))
+		assert.Equal(t, map[string]any{
+			"count":           3,
+			"security_groups": []any{"a", "b"},
+			"public":          true,
+			"name":            "web-3",
+		}, s.Layers[0].Steps[0].Params)
+	})
+
+	t.Run("resolve stack leaves the stack untouched", func(t *testing.T) {
+		s, err := stack.Parse([]byte(resolveStack))
+		require.NoError(t, err)
+		s.RegisteredVariables["my_vpc"]["id"] = "vpc-123"
+		s.Layers[0].Steps[0].Params["zones"] = []any{"{{ $.input.region }}a"}
+
+		first, err := s.ResolveStack(map[string]any{"region": "eu-west-1"}, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		second, err := s.ResolveStack(map[string]any{"region": "us-west-2"}, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "vpc-{{ $.input.region }}", s.Layers[0].Steps[0].Params["name"])
+		assert.Equal(t, []any{"{{ $.input.region }}a"}, s.Layers[0].Steps[0].Params["zones"])
+		assert.Equal(t, []any{"eu-west-1a"}, first.Layers[0].Steps[0].Params["zones"])
+		assert.Equal(t, []any{"us-west-2a"}, second.Layers[0].Steps[0].Params["zones"])
+		assert.Equal(t, "eu-west-1", first.Inputs["region"])
+	})
+
+	t.Run("records value sources", func(t *testing.T) {
+		s, err := stack.Parse([]byte(resolveStack))
+		require.NoError(t, err)
+		s.Layers[0].Steps[0].Params["literal"] = 5
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{Lenient: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, []stack.Source{{Kind: stack.SourceInput, Name: "region"}}, resolved.Provider.Sources["region"])
+		vpc := resolved.Layers[0].Steps[0]
+		assert.Equal(t, []stack.Source{{Kind: stack.SourceLiteral}}, vpc.Sources["literal"])
+		subnet := resolved.Layers[0].Steps[1]
+		require.Len(t, subnet.Sources["vpc_id"], 1)
+		assert.Equal(t, "registered var my_vpc.id", subnet.Sources["vpc_id"][0].String())
+	})
+
+	t.Run("skips steps and layers whose condition doesn't hold", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  environment:
+    type: string
+    default: dev
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: vpc
+        register: my_vpc
+      - name: Create bastion
+        when: '{{ eq $.input.environment "prod" }}'
+        aws.ec2_instance:
+          vpc_id: "{{ $.my_vpc.id }}"
+        register: bastion
+  - name: monitoring
+    when: '{{ if ne $.input.environment "dev" }}true{{ end }}'
+    steps:
+      - name: Alarm
+        aws.alarm:
+          target: "{{ $.bastion.id }}"
+`))
+		require.NoError(t, err)
+		s.RegisteredVariables["my_vpc"]["id"] = "vpc-123"
+		s.RegisteredVariables["bastion"]["id"] = "i-123"
+
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.False(t, resolved.Layers[0].Steps[0].Skipped)
+		assert.True(t, resolved.Layers[0].Steps[1].Skipped)
+		assert.Nil(t, resolved.Layers[0].Steps[1].Params)
+		assert.True(t, resolved.Layers[1].Skipped)
+		assert.True(t, resolved.Layers[1].Steps[0].Skipped)
+
+		resolved, err = s.ResolveStack(map[string]any{"environment": "prod"}, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "vpc-123", resolved.Layers[0].Steps[1].Params["vpc_id"])
+		assert.False(t, resolved.Layers[1].Skipped)
+		assert.Equal(t, "i-123", resolved.Layers[1].Steps[0].Params["target"])
+	})
+
+	t.Run("variables of skipped steps are absent", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: network
+    steps:
+      - name: Create bastion
+        when: "false"
+        aws.ec2_instance:
+          name: bastion
+        register: bastion
+      - name: Record
+        aws.route53_record:
+          target: "{{ $.bastion.ip }}"
+      - name: Invalid
+        when: "{{ 3 }}"
+        aws.alarm:
+          name: alarm
+`))
+		require.NoError(t, err)
+		s.RegisteredVariables["bastion"]["ip"] = "10.0.0.1"
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrMissingValue)
+
+		s.Layers[0].Steps[1].When = "{{ $.bastion.ip }}"
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrMissingValue)
+
+		s.Layers[0].Steps[1].When = "false"
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrInvalidCondition)
+		assert.EqualError(t, err, "failed to resolve condition of step 'Invalid' in layer 'network': invalid condition: expected a bool, got 3")
+	})
+
+	t.Run("expands steps with for_each and count", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  zones:
+    type: list(string)
+    default: [us-east-1a, us-east-1b]
+  replicas:
+    type: integer
+    default: 2
+layers:
+  - name: network
+    steps:
+      - name: Create Subnet
+        for_each: "{{ $.input.zones }}"
+        aws.subnet:
+          availability_zone: "{{ $.each.value }}"
+        register: subnets
+      - name: Tag Subnet
+        for_each:
+          primary: us-east-1a
+          secondary: us-east-1b
+        aws.tag:
+          subnet_id: '{{ (index $.subnets $.each.value).id }}'
+          role: "{{ $.each.key }}"
+  - name: compute
+    steps:
+      - name: Create Instance
+        count: "{{ $.input.replicas }}"
+        aws.ec2_instance:
+          index: "{{ $.each.key }}"
+        register: instances
+      - name: Balance
+        aws.lb:
+          first: "{{ (index $.instances 0).id }}"
+`))
+		require.NoError(t, err)
+		s.RegisteredVariables["subnets"]["us-east-1a"] = map[string]any{"id": "subnet-a"}
+		s.RegisteredVariables["subnets"]["us-east-1b"] = map[string]any{"id": "subnet-b"}
+		s.RegisteredVariables["instances"]["0"] = map[string]any{"id": "i-0"}
+
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		network := resolved.Layers[0].Steps
+		require.Len(t, network, 4)
+		assert.Equal(t, "Create Subnet[us-east-1a]", network[0].Name)
+		assert.Equal(t, "us-east-1a", network[0].Key)
+		assert.Equal(t, "us-east-1b", network[1].Params["availability_zone"])
+		assert.Equal(t, "Tag Subnet[primary]", network[2].Name)
+		assert.Equal(t, 1, network[2].Index)
+		assert.Equal(t, map[string]any{"subnet_id": "subnet-a", "role": "primary"}, network[2].Params)
+		assert.Equal(t, "subnet-b", network[3].Params["subnet_id"])
+
+		compute := resolved.Layers[1].Steps
+		require.Len(t, compute, 3)
+		assert.Equal(t, "Create Instance[1]", compute[1].Name)
+		assert.Equal(t, 1, compute[1].Params["index"])
+		assert.Equal(t, "i-0", compute[2].Params["first"])
+
+		require.NoError(t, s.Resolve(map[string]any{"replicas": 1}, nil))
+		require.Len(t, s.Layers[1].Steps, 2)
+		assert.Equal(t, "Create Instance[0]", s.Layers[1].Steps[0].Name)
+		assert.Nil(t, s.Layers[1].Steps[0].Count)
+	})
+
+	t.Run("fails on invalid loops", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: network
+    steps:
+      - name: Create Subnet
+        for_each: '{{ "a" }}'
+        aws.subnet:
+          name: subnet
+`))
+		require.NoError(t, err)
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrInvalidLoop)
+		assert.EqualError(t, err, "failed to expand step 'Create Subnet' in layer 'network': invalid loop: for_each must be a list or map, got a")
+
+		s.Layers[0].Steps[0].ForEach = []any{"a", "a"}
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorContains(t, err, "for_each has duplicate key 'a'")
+	})
+
+	t.Run("resolves steps in the order of their dependencies", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: balancing
+    steps:
+      - name: Balance
+        aws.lb:
+          targets: "{{ $.instances }}"
+  - name: compute
+    steps:
+      - name: Create Instance
+        count: 2
+        aws.ec2_instance:
+          name: "web-{{ $.each.key }}"
+        register: instances
+`))
+		require.NoError(t, err)
+		s.RegisteredVariables["instances"]["0"] = "i-0"
+		s.RegisteredVariables["instances"]["1"] = "i-1"
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, []any{"i-0", "i-1"}, resolved.Layers[0].Steps[0].Params["targets"])
+	})
+
+	t.Run("resolves the steps of components with their arguments", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  env:
+    type: string
+    default: prod
+components:
+  network_base:
+    inputs:
+      cidr:
+        type: string
+        required: true
+      name:
+        type: string
+        default: main
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "{{ $.input.name }}"
+          cidr_block: "{{ $.input.cidr }}"
+        register: vpc
+      - name: Create Subnet
+        aws.subnet:
+          vpc_id: "{{ $.vpc.id }}"
+        register: subnet
+layers:
+  - name: network
+    steps:
+      - name: Prod
+        use: network_base
+        with:
+          cidr: 10.0.0.0/16
+          name: "{{ $.input.env }}"
+        register: prod
+      - name: Staging
+        use: network_base
+        when: "{{ ne $.input.env \"prod\" }}"
+        with:
+          cidr: 10.1.0.0/16
+        register: staging
+  - name: compute
+    steps:
+      - name: Create Instance
+        aws.ec2_instance:
+          subnet_id: "{{ $.prod.subnet.id }}"
+`))
+		require.NoError(t, err)
+		assert.Contains(t, s.RegisteredVariables, "prod.vpc")
+		assert.Contains(t, s.RegisteredVariables, "staging.subnet")
+		s.RegisteredVariables["prod.vpc"]["id"] = "vpc-1"
+		s.RegisteredVariables["prod.subnet"]["id"] = "subnet-1"
+
+		resolved, err := s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		network := resolved.Layers[0].Steps
+		require.Len(t, network, 4)
+		assert.Equal(t, "Prod/Create VPC", network[0].Name)
+		assert.Equal(t, "prod.vpc", network[0].Register)
+		assert.Equal(t, map[string]any{"name": "prod", "cidr_block": "10.0.0.0/16"}, network[0].Params)
+		assert.Equal(t, "vpc-1", network[1].Params["vpc_id"])
+		assert.True(t, network[2].Skipped)
+		assert.True(t, network[3].Skipped)
+		assert.Equal(t, "subnet-1", resolved.Layers[1].Steps[0].Params["subnet_id"])
+
+		// Arguments are checked against the inputs of the component
+		s2, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+components:
+  network_base:
+    inputs:
+      cidr:
+        type: string
+        required: true
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          cidr_block: "{{ $.input.cidr }}"
+layers:
+  - name: network
+    steps:
+      - name: Prod
+        use: network_base
+`))
+		require.NoError(t, err)
+		_, err = s2.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrMissingInput)
+		assert.ErrorContains(t, err, "invalid inputs of component 'network_base' in step 'Prod' in layer 'network'")
+	})
+
+	t.Run("resolves locals before everything else", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    region: "{{ $.local.region }}"
+inputs:
+  env:
+    type: string
+    default: prod
+  region:
+    type: string
+    default: us-east-1
+locals:
+  name: "{{ $.local.prefix }}-{{ $.input.region }}"
+  prefix: "web-{{ $.input.env }}"
+  region: "{{ $.input.region }}"
+  zones: [a, b]
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "{{ $.local.name }}"
+          zones: "{{ $.local.zones }}"
+`))
+		require.NoError(t, err)
+		resolved, err := s.ResolveStack(map[string]any{"env": "dev"}, nil, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "web-dev-us-east-1", resolved.Locals["name"])
+		assert.Equal(t, "us-east-1", resolved.Provider.Properties["region"])
+		step := resolved.Layers[0].Steps[0]
+		assert.Equal(t, map[string]any{"name": "web-dev-us-east-1", "zones": []any{"a", "b"}}, step.Params)
+		assert.Equal(t, []stack.Source{{Kind: stack.SourceLocal, Name: "name"}}, step.Sources["name"])
+
+		s.Locals["prefix"] = "{{ $.local.name }}"
+		_, err = s.ResolveStack(nil, nil, stack.ResolveOptions{})
+		assert.ErrorIs(t, err, stack.ErrLocalCycle)
+		assert.EqualError(t, err, "local cycle: name -> prefix -> name")
+	})
+
+	t.Run("resolves outputs once variables are registered", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  replicas:
+    type: number
+    default: 2
+layers:
+  - name: compute
+    steps:
+      - name: Launch Instance
+        count: "{{ $.input.replicas }}"
+        aws.ec2:
+          name: "web-{{ $.each.key }}"
+        register: web
+outputs:
+  first_id:
+    value: "{{ (index $.web 0).id }}"
+    description: ID of the first instance
+  ips:
+    type: list(string)
+    value:
+      - "{{ (index $.web 0).ip }}"
+      - "{{ (index $.web 1).ip }}"
+  replicas:
+    type: string
+    value: "{{ $.input.replicas }}"
+  password:
+    value: "{{ $.secret.password }}"
+    sensitive: true
+`))
+		require.NoError(t, err)
+		s.RegisteredVariables["web"]["0"] = map[string]any{"id": "i-0", "ip": "10.0.0.1"}
+		s.RegisteredVariables["web"]["1"] = map[string]any{"id": "i-1", "ip": "10.0.0.2"}
+
+		outputs, err := s.ResolveOutputs(nil, map[string]string{"password": "hunter2"}, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, "i-0", outputs["first_id"].Value)
+		assert.Equal(t, "ID of the first instance", outputs["first_id"].Description)
+		assert.Equal(t, []any{"10.0.0.1", "10.0.0.2"}, outputs["ips"].Value)
+		assert.Equal(t, "2", outputs["replicas"].Value)
+		assert.Equal(t, "hunter2", outputs["password"].Value)
+		assert.Equal(t, "(sensitive)", outputs["password"].String())
+		assert.Equal(t, []stack.Source{{Kind: stack.SourceSecret, Name: "password"}}, outputs["password"].Sources[""])
+
+		delete(s.RegisteredVariables["web"], "1")
+		_, err = s.ResolveOutputs(nil, map[string]string{"password": "hunter2"}, stack.ResolveOptions{})
+		assert.ErrorContains(t, err, "failed to resolve output 'ips': param '[1]'")
+	})
+
+	t.Run("marks values derived from secrets as sensitive", func(t *testing.T) {
+		s, err := stack.Parse([]byte(`
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    region: us-east-1
+    secret_key: "{{ $.secret.aws_secret_key }}"
+locals:
+  conn: "postgres://admin:{{ $.secret.db_password }}@db"
+  host: db
+components:
+  user:
+    inputs:
+      password:
+        type: string
+        required: true
+    steps:
+      - name: Create User
+        aws.rds_user:
+          password: "{{ $.input.password }}"
+layers:
+  - name: database
+    steps:
+      - name: Create Database
+        aws.rds:
+          host: "{{ $.local.host }}"
+          settings:
+            url: "{{ $.local.conn }}"
+      - name: Admin
+        use: user
+        with:
+          password: "{{ $.secret.db_password }}"
+outputs:
+  connection:
+    value: "{{ $.local.conn }}"
+  host:
+    value: "{{ $.local.host }}"
+`))
+		require.NoError(t, err)
+		secrets := map[string]string{"aws_secret_key": "key", "db_password": "pass"}
+		resolved, err := s.ResolveStack(nil, secrets, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]bool{"secret_key": true}, resolved.Provider.Sensitive)
+		assert.Equal(t, map[string]bool{"conn": true, "host": false}, resolved.SensitiveLocals)
+		assert.Equal(t, map[string]bool{"settings.url": true}, resolved.Layers[0].Steps[0].Sensitive)
+		assert.Equal(t, map[string]bool{"password": true}, resolved.Layers[0].Steps[1].Sensitive)
+
+		outputs, err := s.ResolveOutputs(nil, secrets, stack.ResolveOptions{})
+		require.NoError(t, err)
+		assert.True(t, outputs["connection"].Sensitive)
+		assert.Equal(t, "(sensitive)", outputs["connection"].String())
+		assert.False(t, outputs["host"].Sensitive)
+	})
+}
@@ -0,0 +1,167 @@
+package stack
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SourceKind is the kind of place a resolved value came from
+type SourceKind string
+
+const (
+	SourceLiteral  SourceKind = "literal"
+	SourceInput    SourceKind = "input"
+	SourceSecret   SourceKind = "secret"
+	SourceVariable SourceKind = "variable"
+	SourceLocal    SourceKind = "local"
+	// The key or value of the instance of an expanded step
+	SourceEach SourceKind = "each"
+)
+
+// Source is one of the places a resolved value came from
+type Source struct {
+	Kind SourceKind `json:"kind"`
+	// Name of the input, secret, local or registered variable, or "key" or "value" of each
+	Name string `json:"name,omitempty"`
+	// Attribute of a registered variable, e.g. "id" in $.my_vpc.id
+	Attr string `json:"attr,omitempty"`
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceInput:
+		return "input " + s.Name
+	case SourceSecret:
+		return "secret " + s.Name
+	case SourceLocal:
+		return "local " + s.Name
+	case SourceEach:
+		return "each." + s.Name
+	case SourceVariable:
+		if s.Attr != "" {
+			return "registered var " + s.Name + "." + s.Attr
+		}
+		return "registered var " + s.Name
+	}
+	return string(s.Kind)
+}
+
+// ResolvedStack holds the values of a stack after all templates were resolved
+type ResolvedStack struct {
+	// The prepared input values the stack was resolved with
+	Inputs map[string]any
+	// The resolved values of the stack's locals, and which of them are derived
+	// from secrets
+	Locals          map[string]any
+	SensitiveLocals map[string]bool
+	Provider        ResolvedProvider
+	Layers          []ResolvedLayer
+}
+
+type ResolvedProvider struct {
+	Type       string
+	Properties map[string]any
+	// Where each property came from, keyed by path such as "tags.owner"
+	Sources map[string][]Source
+	// Paths of the properties derived from secrets, which must be kept hidden
+	Sensitive map[string]bool
+}
+
+type ResolvedLayer struct {
+	Name string
+	// The layer's condition, and whether it didn't hold
+	When    string
+	Skipped bool
+	Steps   []ResolvedStep
+}
+
+type ResolvedStep struct {
+	// Name of the step. Instances of steps expanded with for_each or count are
+	// named after their key, like "Create Subnet[us-east-1a]".
+	Name string
+	// Index of the step in its layer of the stack. All instances of an
+	// expanded step share the same index.
+	Index int
+	// Key of the instance of an expanded step, or nil if the step isn't expanded
+	Key      any
+	Action   string
+	Register string
+	Tags     []string
+	// The step's condition, and whether it or its layer's condition didn't
+	// hold. Skipped steps have no params and don't register their variable.
+	When    string
+	Skipped bool
+	Params  map[string]any
+	// Where each param came from, keyed by path such as "ingress[0].from_port"
+	Sources map[string][]Source
+	// Paths of the params derived from secrets, which must be kept hidden
+	Sensitive map[string]bool
+}
+
+// ResolvedOutput is the value of an output after its templates were resolved
+type ResolvedOutput struct {
+	Value       any
+	Description string
+	Type        string
+	// Sensitive values are hidden when the output is shown. Outputs derived
+	// from secrets are always sensitive.
+	Sensitive bool
+	// Where the value came from, keyed by path such as "subnets[0]". The
+	// sources of a value that isn't a list or map have an empty path.
+	Sources map[string][]Source
+}
+
+// String formats the value of the output, or hides it if it's sensitive
+func (o ResolvedOutput) String() string {
+	if o.Sensitive {
+		return "(sensitive)"
+	}
+	return fmt.Sprint(o.Value)
+}
+
+// Returns the sources referenced by a template string. Templates that don't
+// reference anything are literals.
+func templateSources(tmplStr string) []Source {
+	varPaths, err := ExtractVariablePaths(tmplStr)
+	if err != nil {
+		return nil
+	}
+	var sources []Source
+	for _, parts := range varPaths {
+		if len(parts) == 0 {
+			continue
+		}
+		var src Source
+		switch parts[0] {
+		case "input":
+			src = Source{Kind: SourceInput}
+		case "secret":
+			src = Source{Kind: SourceSecret}
+		case "local":
+			src = Source{Kind: SourceLocal}
+		case "each":
+			src = Source{Kind: SourceEach}
+		default:
+			src = Source{Kind: SourceVariable, Name: parts[0], Attr: strings.Join(parts[1:], ".")}
+		}
+		if src.Kind != SourceVariable && len(parts) > 1 {
+			src.Name = parts[1]
+		}
+		if !containsSource(sources, src) {
+			sources = append(sources, src)
+		}
+	}
+	if len(sources) == 0 {
+		return []Source{{Kind: SourceLiteral}}
+	}
+	return sources
+}
+
+func containsSource(sources []Source, src Source) bool {
+	for _, s := range sources {
+		if s == src {
+			return true
+		}
+	}
+	return false
+}
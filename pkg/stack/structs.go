@@ -1,6 +1,9 @@
 package stack
 
 type Stack struct {
+	// Files to include in the stack, see Load. Imports is an alias of Include.
+	Include     []string          `yaml:"include,omitempty"`
+	Imports     []string          `yaml:"imports,omitempty"`
 	Version     string            `yaml:"version"`
 	Name        string            `yaml:"name"`
 	DisplayName string            `yaml:"display_name"`
@@ -10,13 +13,25 @@ type Stack struct {
 	Inputs      map[string]Input  `yaml:"inputs,omitempty"`
 	Layers      []Layer           `yaml:"layers"`
 	Outputs     map[string]Output `yaml:"outputs"`
+	// Values computed from inputs, secrets and other locals, available to
+	// templates as $.local.name
+	Locals map[string]any `yaml:"locals,omitempty"`
+	// Reusable groups of steps, used by steps with "use"
+	Components map[string]Component `yaml:"components,omitempty"`
 	// Contains all registered variables from steps that contain a "register" attribute
 	RegisteredVariables map[string]map[string]any
+	// Where the stack document starts
+	Pos       Position `yaml:"-"`
+	positions positions
+	// Comments that ignore lint rules
+	ignores []lintIgnore
 }
 
 type Provider struct {
 	Type       string         `yaml:"type"`
 	Properties map[string]any `yaml:"properties,omitempty"`
+	Pos        Position       `yaml:"-"`
+	positions  positions
 }
 
 type Secret struct {
@@ -24,6 +39,8 @@ type Secret struct {
 	Allowed     []AllowedValue `yaml:"allowed,omitempty"`
 	Description string         `yaml:"description,omitempty"`
 	Label       string         `yaml:"label,omitempty"`
+	Pos         Position       `yaml:"-"`
+	positions   positions
 }
 
 type Input struct {
@@ -33,6 +50,17 @@ type Input struct {
 	Required    bool           `yaml:"required,omitempty"`
 	Description string         `yaml:"description,omitempty"`
 	Label       string         `yaml:"label,omitempty"`
+	// Constraints on the values that can be given for the input
+	Pattern   string   `yaml:"pattern,omitempty"`
+	Min       *float64 `yaml:"min,omitempty"`
+	Max       *float64 `yaml:"max,omitempty"`
+	MinLength *int     `yaml:"min_length,omitempty"`
+	MaxLength *int     `yaml:"max_length,omitempty"`
+	Unique    bool     `yaml:"unique,omitempty"`
+	// Custom error messages, keyed by constraint name
+	Messages  map[string]string `yaml:"messages,omitempty"`
+	Pos       Position          `yaml:"-"`
+	positions positions
 }
 
 type AllowedValue struct {
@@ -41,8 +69,14 @@ type AllowedValue struct {
 }
 
 type Layer struct {
-	Name  string `yaml:"name"`
-	Steps []Step `yaml:"steps"`
+	Name string `yaml:"name"`
+	// Condition the layer's steps only run under, e.g. "{{ eq $.input.env \"prod\" }}"
+	When      string   `yaml:"when,omitempty"`
+	Steps     []Step   `yaml:"steps"`
+	Pos       Position `yaml:"-"`
+	positions positions
+	// Set when the layer holds the steps of a component during validation
+	component bool
 }
 
 type Step struct {
@@ -51,10 +85,44 @@ type Step struct {
 	Params   map[string]any `yaml:"-"`
 	Register string         `yaml:"register,omitempty"`
 	Tags     []string       `yaml:"tags,omitempty"`
-	Raw      map[string]any `yaml:",inline"`
+	// Condition the step only runs under
+	When string `yaml:"when,omitempty"`
+	// Expand the step into an instance per element of a list or map, or into
+	// count instances. Either can be a template.
+	ForEach any `yaml:"for_each,omitempty"`
+	Count   any `yaml:"count,omitempty"`
+	// Names of steps this step depends on, on top of the steps whose registered
+	// variables it references
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// Name of a component whose steps to run in place of this step, and the
+	// values of the component's inputs
+	Use       string         `yaml:"use,omitempty"`
+	With      map[string]any `yaml:"with,omitempty"`
+	Raw       map[string]any `yaml:",inline"`
+	Pos       Position       `yaml:"-"`
+	positions positions
+	// The use of a component the step was expanded from
+	use *componentUse
+}
+
+// Component is a group of steps that can be used in layers with different
+// inputs. The variables registered by its steps are namespaced by each use.
+type Component struct {
+	Description string           `yaml:"description,omitempty"`
+	Inputs      map[string]Input `yaml:"inputs,omitempty"`
+	Steps       []Step           `yaml:"steps"`
+	Pos         Position         `yaml:"-"`
+	positions   positions
 }
 
 type Output struct {
-	Value       string `yaml:"value"`
+	// A template, or a list or map of values that can contain templates
+	Value       any    `yaml:"value"`
 	Description string `yaml:"description"`
+	// Type the resolved value is converted to, if set
+	Type string `yaml:"type,omitempty"`
+	// Sensitive values are hidden when shown
+	Sensitive bool     `yaml:"sensitive,omitempty"`
+	Pos       Position `yaml:"-"`
+	positions positions
 }
@@ -0,0 +1,106 @@
+package stack
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Returns the names of the secrets a value is derived from, directly or through
+// locals, in sorted order
+func (s *Stack) secretsIn(val any) []string {
+	var secrets []string
+	visited := make(map[string]bool)
+	var walk func(val any)
+	walk = func(val any) {
+		for _, parts := range templateReferences(val) {
+			if len(parts) < 2 {
+				continue
+			}
+			switch parts[0] {
+			case "secret":
+				if !slices.Contains(secrets, parts[1]) {
+					secrets = append(secrets, parts[1])
+				}
+			case "local":
+				if !visited[parts[1]] {
+					visited[parts[1]] = true
+					walk(s.Locals[parts[1]])
+				}
+			}
+		}
+	}
+	walk(val)
+	slices.Sort(secrets)
+	return secrets
+}
+
+// Checks that values derived from secrets only end up where they are kept
+// hidden: in provider properties, step params and sensitive outputs. Names,
+// tags and the keys of for_each are shown wherever the stack is.
+func (s *Stack) validateSecrets(diags *Diagnostics) {
+	report := func(pos Position, what string, secrets []string) {
+		diags.errorf(pos, CodeSecretLeak, "%s is derived from %s", what, describeSecrets(secrets))
+	}
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		if secrets := s.secretsIn(layer.Name); len(secrets) > 0 {
+			report(layer.positions.at("name", layer.Pos), fmt.Sprintf("name of layer '%s'", layer.Name), secrets)
+		}
+		for j := range layer.Steps {
+			step := &layer.Steps[j]
+			// The steps of components are checked with the component
+			if step.use != nil {
+				continue
+			}
+			where := fmt.Sprintf("step '%s' in %s", step.Name, layer.describe())
+			if secrets := s.secretsIn(step.Name); len(secrets) > 0 {
+				report(step.positions.at("name", step.Pos), "name of "+where, secrets)
+			}
+			for k, tag := range step.Tags {
+				if secrets := s.secretsIn(tag); len(secrets) > 0 {
+					report(step.positions.at(fmt.Sprintf("tags[%d]", k), step.Pos), "tag of "+where, secrets)
+				}
+			}
+			// Instances are named after their keys
+			if secrets := s.secretsIn(step.ForEach); len(secrets) > 0 {
+				report(step.positions.at("for_each", step.Pos), "for_each of "+where, secrets)
+			}
+		}
+	}
+	for _, name := range sortedKeys(s.Outputs) {
+		output := s.Outputs[name]
+		if secrets := s.secretsIn(output.Value); len(secrets) > 0 && !output.Sensitive {
+			diags.errorf(output.positions.at("value", output.Pos), CodeSecretLeak, "output '%s' is derived from %s but isn't marked sensitive", name, describeSecrets(secrets))
+		}
+	}
+}
+
+// Describes a list of secrets, like "secrets 'a' and 'b'"
+func describeSecrets(secrets []string) string {
+	quoted := make([]string, len(secrets))
+	for i, secret := range secrets {
+		quoted[i] = "'" + secret + "'"
+	}
+	if len(quoted) == 1 {
+		return "secret " + quoted[0]
+	}
+	return "secrets " + strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+}
+
+// Returns the paths of the resolved values that are derived from secrets,
+// directly or through locals
+func (r *resolver) sensitivePaths(sources map[string][]Source) map[string]bool {
+	sensitive := make(map[string]bool)
+	for path, srcs := range sources {
+		for _, src := range srcs {
+			switch {
+			case src.Kind == SourceSecret,
+				src.Kind == SourceLocal && r.sensitiveLocals[src.Name],
+				src.Kind == SourceInput && r.sensitiveInputs[src.Name]:
+				sensitive[path] = true
+			}
+		}
+	}
+	return sensitive
+}
@@ -0,0 +1,319 @@
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidType = errors.New("invalid type")
+
+// Type is the declared type of a stack input. Types are written as
+//
+//	string
+//	number
+//	integer
+//	bool
+//	list(T)
+//	map(T)
+//	object(name=T, other=T)
+type Type interface {
+	String() string
+	// Convert checks a value against the type and returns it as the type's Go
+	// representation. Strings are parsed into numbers and bools where possible,
+	// so values coming from the command line can be converted too.
+	Convert(val any) (any, error)
+}
+
+// PrimitiveType is one of the scalar types
+type PrimitiveType string
+
+const (
+	TypeString  PrimitiveType = "string"
+	TypeNumber  PrimitiveType = "number"
+	TypeInteger PrimitiveType = "integer"
+	TypeBool    PrimitiveType = "bool"
+)
+
+// ListType is an ordered list with elements of the same type
+type ListType struct {
+	Elem Type
+}
+
+// MapType is a map of string keys to values of the same type
+type MapType struct {
+	Elem Type
+}
+
+// ObjectType is a map with a fixed set of fields, each with its own type
+type ObjectType struct {
+	Fields map[string]Type
+}
+
+// ParseType parses a type expression such as "list(string)"
+func ParseType(expr string) (Type, error) {
+	p := typeParser{src: expr}
+	t, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("%w '%s': %v", ErrInvalidType, expr, err)
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("%w '%s': unexpected '%s'", ErrInvalidType, expr, p.src[p.pos:])
+	}
+	return t, nil
+}
+
+type typeParser struct {
+	src string
+	pos int
+}
+
+func (p *typeParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *typeParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			break
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *typeParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != c {
+		return fmt.Errorf("expected '%c' at offset %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *typeParser) peek(c byte) bool {
+	p.skipSpace()
+	return p.pos < len(p.src) && p.src[p.pos] == c
+}
+
+func (p *typeParser) parse() (Type, error) {
+	name := p.ident()
+	switch name {
+	case "string", "number", "integer", "bool":
+		return PrimitiveType(name), nil
+	case "list", "map":
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		elem, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		if name == "list" {
+			return ListType{Elem: elem}, nil
+		}
+		return MapType{Elem: elem}, nil
+	case "object":
+		if err := p.expect('('); err != nil {
+			return nil, err
+		}
+		obj := ObjectType{Fields: make(map[string]Type)}
+		for !p.peek(')') {
+			if len(obj.Fields) > 0 {
+				if err := p.expect(','); err != nil {
+					return nil, err
+				}
+			}
+			field := p.ident()
+			if field == "" {
+				return nil, fmt.Errorf("expected field name at offset %d", p.pos)
+			}
+			if _, ok := obj.Fields[field]; ok {
+				return nil, fmt.Errorf("duplicate field '%s'", field)
+			}
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			fieldType, err := p.parse()
+			if err != nil {
+				return nil, err
+			}
+			obj.Fields[field] = fieldType
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case "":
+		return nil, fmt.Errorf("expected type name at offset %d", p.pos)
+	default:
+		return nil, fmt.Errorf("expected one of string, number, integer, bool, list, map or object")
+	}
+}
+
+func (t PrimitiveType) String() string {
+	return string(t)
+}
+
+func (t PrimitiveType) Convert(val any) (any, error) {
+	switch t {
+	case TypeString:
+		switch v := val.(type) {
+		case string:
+			return v, nil
+		case int, int64, float64, bool:
+			return fmt.Sprint(v), nil
+		}
+	case TypeNumber:
+		switch v := val.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return nil, fmt.Errorf("expected %s, got %v", t, v)
+			}
+			return v, nil
+		case string:
+			if i, err := strconv.Atoi(v); err == nil {
+				return i, nil
+			}
+			// NaN and infinities can't be encoded as JSON
+			if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return f, nil
+			}
+		}
+	case TypeInteger:
+		switch v := val.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				if v < math.MinInt || v >= math.MaxInt {
+					return nil, fmt.Errorf("number %v is out of range for %s", v, t)
+				}
+				return int(v), nil
+			}
+		case string:
+			if i, err := strconv.Atoi(v); err == nil {
+				return i, nil
+			}
+		}
+	case TypeBool:
+		switch v := val.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b, nil
+			}
+		}
+	}
+	return nil, typeMismatch(t, val)
+}
+
+func (t ListType) String() string {
+	return "list(" + t.Elem.String() + ")"
+}
+
+func (t ListType) Convert(val any) (any, error) {
+	rv := reflect.ValueOf(val)
+	if rv.Kind() != reflect.Slice {
+		return nil, typeMismatch(t, val)
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		elem, err := t.Elem.Convert(rv.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+		out[i] = elem
+	}
+	return out, nil
+}
+
+func (t MapType) String() string {
+	return "map(" + t.Elem.String() + ")"
+}
+
+func (t MapType) Convert(val any) (any, error) {
+	m, ok := val.(map[string]any)
+	if !ok {
+		return nil, typeMismatch(t, val)
+	}
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		elem, err := t.Elem.Convert(v)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s': %w", k, err)
+		}
+		out[k] = elem
+	}
+	return out, nil
+}
+
+func (t ObjectType) String() string {
+	fields := make([]string, 0, len(t.Fields))
+	for _, name := range sortedKeys(t.Fields) {
+		fields = append(fields, name+"="+t.Fields[name].String())
+	}
+	return "object(" + strings.Join(fields, ", ") + ")"
+}
+
+func (t ObjectType) Convert(val any) (any, error) {
+	m, ok := val.(map[string]any)
+	if !ok {
+		return nil, typeMismatch(t, val)
+	}
+	for _, k := range sortedKeys(m) {
+		if _, ok := t.Fields[k]; !ok {
+			return nil, fmt.Errorf("unknown field '%s'", k)
+		}
+	}
+	out := make(map[string]any, len(m))
+	for _, name := range sortedKeys(t.Fields) {
+		v, ok := m[name]
+		if !ok {
+			return nil, fmt.Errorf("missing field '%s'", name)
+		}
+		field, err := t.Fields[name].Convert(v)
+		if err != nil {
+			return nil, fmt.Errorf("field '%s': %w", name, err)
+		}
+		out[name] = field
+	}
+	return out, nil
+}
+
+func typeMismatch(t Type, val any) error {
+	switch v := val.(type) {
+	case nil:
+		return fmt.Errorf("expected %s, got null", t)
+	case string:
+		return fmt.Errorf("expected %s, got string %q", t, v)
+	case bool:
+		return fmt.Errorf("expected %s, got bool %v", t, v)
+	case int, int64, float64:
+		return fmt.Errorf("expected %s, got number %v", t, v)
+	case map[string]any:
+		return fmt.Errorf("expected %s, got map", t)
+	}
+	if reflect.ValueOf(val).Kind() == reflect.Slice {
+		return fmt.Errorf("expected %s, got list", t)
+	}
+	return fmt.Errorf("expected %s, got %T", t, val)
+}
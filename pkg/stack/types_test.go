@@ -0,0 +1,96 @@
+package stack_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/groundctl/groundctl/pkg/stack"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseType(t *testing.T) {
+	t.Run("valid types", func(t *testing.T) {
+		cases := []struct {
+			input    string
+			expected string
+		}{
+			{input: "string", expected: "string"},
+			{input: "list(number)", expected: "list(number)"},
+			{input: "map( list(bool) )", expected: "map(list(bool))"},
+			{input: "object(port=integer, name=string)", expected: "object(name=string, port=integer)"},
+			{input: "list(object(cidr=string))", expected: "list(object(cidr=string))"},
+		}
+		for _, tt := range cases {
+			t.Run(tt.input, func(t *testing.T) {
+				typ, err := stack.ParseType(tt.input)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, typ.String())
+			})
+		}
+	})
+
+	t.Run("invalid types", func(t *testing.T) {
+		for _, input := range []string{"", "strng", "list", "list(string", "map(string))", "object(a=string, a=number)"} {
+			_, err := stack.ParseType(input)
+			assert.ErrorIs(t, err, stack.ErrInvalidType, input)
+		}
+	})
+}
+
+func TestTypeConvert(t *testing.T) {
+	t.Run("valid values", func(t *testing.T) {
+		cases := []struct {
+			typ      string
+			input    any
+			expected any
+		}{
+			{typ: "string", input: 12, expected: "12"},
+			{typ: "number", input: "1.5", expected: 1.5},
+			{typ: "number", input: "3", expected: 3},
+			{typ: "integer", input: 4.0, expected: 4},
+			{typ: "bool", input: "true", expected: true},
+			{typ: "list(integer)", input: []any{"1", 2}, expected: []any{1, 2}},
+			{typ: "map(bool)", input: map[string]any{"a": "false"}, expected: map[string]any{"a": false}},
+			{
+				typ:      "object(name=string, port=integer)",
+				input:    map[string]any{"name": "ssh", "port": "22"},
+				expected: map[string]any{"name": "ssh", "port": 22},
+			},
+		}
+		for _, tt := range cases {
+			t.Run(tt.typ, func(t *testing.T) {
+				typ, err := stack.ParseType(tt.typ)
+				require.NoError(t, err)
+				val, err := typ.Convert(tt.input)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, val)
+			})
+		}
+	})
+
+	t.Run("invalid values", func(t *testing.T) {
+		cases := []struct {
+			typ   string
+			input any
+			err   string
+		}{
+			{typ: "number", input: "abc", err: `expected number, got string "abc"`},
+			{typ: "number", input: "NaN", err: `expected number, got string "NaN"`},
+			{typ: "number", input: "-Inf", err: `expected number, got string "-Inf"`},
+			{typ: "number", input: math.Inf(1), err: "expected number, got +Inf"},
+			{typ: "integer", input: 1.5, err: "expected integer, got number 1.5"},
+			{typ: "integer", input: 1e300, err: "number 1e+300 is out of range for integer"},
+			{typ: "list(string)", input: "a", err: `expected list(string), got string "a"`},
+			{typ: "list(bool)", input: []any{true, "x"}, err: `element 1: expected bool, got string "x"`},
+			{typ: "object(name=string)", input: map[string]any{}, err: "missing field 'name'"},
+			{typ: "object(name=string)", input: map[string]any{"name": "a", "x": 1}, err: "unknown field 'x'"},
+		}
+		for _, tt := range cases {
+			typ, err := stack.ParseType(tt.typ)
+			require.NoError(t, err)
+			_, err = typ.Convert(tt.input)
+			assert.EqualError(t, err, tt.err)
+		}
+	})
+}
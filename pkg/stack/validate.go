@@ -1,170 +1,423 @@
 package stack
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-// Validate will ensure all fields in the stack template are valid
+// Validate will ensure all fields in the stack template are valid. The
+// returned error joins every problem found by Diagnose.
 func (s *Stack) Validate() error {
+	return s.Diagnose().Err()
+}
+
+// Diagnose checks the whole stack template and returns every problem found,
+// ordered by position
+func (s *Stack) Diagnose() Diagnostics {
+	var diags Diagnostics
 	// Validate metadata fields
-	if err := s.validateMetadata(); err != nil {
-		return err
-	}
+	s.validateMetadata(&diags)
 	// Validate inputs
-	if err := s.validateInputs(); err != nil {
-		return err
-	}
+	s.validateInputs(&diags)
 	// Validate layers (and steps)
-	if err := s.validateLayers(); err != nil {
-		return err
-	}
+	s.validateLayers(&diags)
 	// Validate all templates
-	if err := s.validateTemplates(); err != nil {
-		return err
-	}
-	return nil
+	s.validateTemplates(&diags)
+	// Validate the dependencies between steps
+	s.validateDependencies(&diags)
+	// Validate components and the steps that use them
+	s.validateComponents(&diags)
+	diags.Sort()
+	return diags
 }
 
-func (s *Stack) validateMetadata() error {
+func (s *Stack) validateMetadata(diags *Diagnostics) {
 	logrus.WithField("stack", s.Name).Trace("Validating stack metadata")
 	if s.Version == "" || s.Name == "" || s.Provider.Type == "" {
-		return fmt.Errorf("stack is missing required metadata fields")
+		diags.errorf(s.Pos, CodeMissingMetadata, "stack is missing required metadata fields")
 	}
-	return nil
 }
 
-func (s *Stack) validateInputs() error {
+func (s *Stack) validateInputs(diags *Diagnostics) {
 	logrus.WithField("stack", s.Name).Trace("Validating stack inputs")
 	for name, input := range s.Inputs {
 		if input.Type == "" {
-			return fmt.Errorf("input '%s' must have a type", name)
+			diags.errorf(input.Pos, CodeInputMissingType, "input '%s' must have a type", name)
 		}
 		if !input.Required && input.Default == nil {
-			return fmt.Errorf("input '%s' is not required but has no default value", name)
-		}
-		if len(input.Allowed) > 0 && input.Default != nil {
-			allowed := false
-			for _, val := range input.Allowed {
-				if input.Default == val.Value {
-					allowed = true
-					break
-				}
+			diags.errorf(input.Pos, CodeInputMissingDefault, "input '%s' is not required but has no default value", name)
+		}
+		if input.Type == "" {
+			continue
+		}
+		inputType, err := ParseType(input.Type)
+		if err != nil {
+			diags.errorf(input.positions.at("type", input.Pos), CodeInputInvalidType, "input '%s' has an %v", name, err)
+			continue
+		}
+		// Check the constraints can be applied to the declared type
+		constraintErrs := input.validateConstraints(inputType)
+		for _, err := range constraintErrs {
+			diags.errorf(input.Pos, CodeInvalidConstraint, "input '%s' has an invalid constraint: %v", name, err)
+		}
+		// Check the default and allowed values against the declared type
+		defaultValue := input.Default
+		if defaultValue != nil {
+			if converted, err := inputType.Convert(defaultValue); err != nil {
+				diags.errorf(input.positions.at("default", input.Pos), CodeDefaultTypeMismatch, "default value of input '%s' is not a valid %s: %v", name, inputType, err)
+				defaultValue = nil
+			} else {
+				defaultValue = converted
 			}
-			if !allowed {
-				return fmt.Errorf("default value of input '%s' is not in the allowed list", name)
+		}
+		if defaultValue != nil && len(constraintErrs) == 0 {
+			for _, err := range unwrapJoined(input.CheckConstraints(defaultValue)) {
+				diags.errorf(input.positions.at("default", input.Pos), CodeDefaultConstraint, "default value of input '%s' is invalid: %v", name, err)
+			}
+		}
+		allowedValues := make([]any, 0, len(input.Allowed))
+		for i, val := range input.Allowed {
+			allowedValue, err := inputType.Convert(val.Value)
+			if err != nil {
+				diags.errorf(input.positions.at(fmt.Sprintf("allowed[%d].value", i), input.Pos), CodeAllowedTypeMismatch, "allowed value of input '%s' is not a valid %s: %v", name, inputType, err)
+				continue
 			}
+			allowedValues = append(allowedValues, allowedValue)
+		}
+		if len(input.Allowed) > 0 && defaultValue != nil && !containsValue(allowedValues, defaultValue) {
+			diags.errorf(input.positions.at("default", input.Pos), CodeDefaultNotAllowed, "default value of input '%s' is not in the allowed list", name)
+		}
+	}
+}
+
+// Splits an error created by errors.Join back into its parts
+func unwrapJoined(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// Reports whether vals contains a value deeply equal to val
+func containsValue(vals []any, val any) bool {
+	for _, v := range vals {
+		if reflect.DeepEqual(v, val) {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
-func (s *Stack) validateLayers() error {
+func (s *Stack) validateLayers(diags *Diagnostics) {
 	logrus.WithField("stack", s.Name).Trace("Validating stack layers")
 	for _, layer := range s.Layers {
 		if layer.Name == "" {
-			return fmt.Errorf("layer is missing a name")
+			diags.errorf(layer.Pos, CodeLayerMissingName, "layer is missing a name")
 		}
 		// Validate steps
-		if err := layer.validateSteps(); err != nil {
-			return err
-		}
+		layer.validateSteps(diags)
 	}
-	return nil
 }
 
-func (l *Layer) validateSteps() error {
+func (l *Layer) validateSteps(diags *Diagnostics) {
 	logrus.WithField("layer", l.Name).Trace("Validating layer steps")
 	for _, step := range l.Steps {
+		// The steps of components are validated with the component
+		if step.use != nil {
+			continue
+		}
 		if step.Name == "" || step.Action == "" {
-			return fmt.Errorf("step in layer '%s' is missing name or action", l.Name)
+			diags.errorf(step.Pos, CodeStepMissingFields, "step in %s is missing name or action", l.describe())
+		}
+		step.validateLoop(diags, l.describe())
+	}
+}
+
+// Checks the for_each or count of a step. Templates are checked along with all
+// other templates.
+func (t *Step) validateLoop(diags *Diagnostics, layer string) {
+	if t.ForEach != nil && t.Count != nil {
+		diags.errorf(t.positions.at("count", t.Pos), CodeInvalidLoop, "step '%s' in %s can't have both for_each and count", t.Name, layer)
+	}
+	switch v := t.ForEach.(type) {
+	case nil, []any, map[string]any:
+	case string:
+		if !strings.Contains(v, "{{") {
+			diags.errorf(t.positions.at("for_each", t.Pos), CodeInvalidLoop, "for_each of step '%s' in %s must be a list, a map or a template", t.Name, layer)
+		}
+	default:
+		diags.errorf(t.positions.at("for_each", t.Pos), CodeInvalidLoop, "for_each of step '%s' in %s must be a list, a map or a template", t.Name, layer)
+	}
+	switch v := t.Count.(type) {
+	case nil:
+	case int:
+		if v < 0 {
+			diags.errorf(t.positions.at("count", t.Pos), CodeInvalidLoop, "count of step '%s' in %s can't be negative", t.Name, layer)
+		}
+	case string:
+		if !strings.Contains(v, "{{") {
+			diags.errorf(t.positions.at("count", t.Pos), CodeInvalidLoop, "count of step '%s' in %s must be a whole number or a template", t.Name, layer)
 		}
+	default:
+		diags.errorf(t.positions.at("count", t.Pos), CodeInvalidLoop, "count of step '%s' in %s must be a whole number or a template", t.Name, layer)
 	}
-	return nil
 }
 
-func (s *Stack) validateTemplates() error {
+func (s *Stack) validateTemplates(diags *Diagnostics) {
 	logrus.WithField("stack", s.Name).Trace("Validating stack templates")
-	var tmplCheck func(val any) error
-	tmplCheck = func(val any) error {
-		switch v := val.(type) {
-		case string:
-			// Check if string contains a template
-			if !strings.Contains(v, "{{") {
-				return nil
-			}
-			varPaths, err := ExtractVariablePaths(v)
-			if err != nil {
-				return err
-			}
+	registered := s.alwaysRegistered()
 
-			// Validate each variable path
-			for _, parts := range varPaths {
-				if len(parts) == 0 {
-					continue
-				}
-				root := parts[0]
+	// Validate provider properties. The provider is configured before any step runs.
+	provider := templateCheck{context: "failed to resolve provider properties", beforeSteps: true, registered: registered}
+	s.checkTemplates(diags, provider, s.Provider.Properties, "properties", s.Provider.positions, s.Provider.Pos)
 
-				switch root {
-				case "input":
-					if len(parts) < 2 {
-						return fmt.Errorf("invalid input reference: '%s' (missing key)", strings.Join(parts, "."))
-					}
-					if _, ok := s.Inputs[parts[1]]; !ok {
-						return fmt.Errorf("undefined input '%s'", parts[1])
-					}
-				case "secret":
-					if len(parts) < 2 {
-						return fmt.Errorf("invalid secret reference: '%s' (missing key)", strings.Join(parts, "."))
-					}
-					if _, ok := s.Secrets[parts[1]]; !ok {
-						return fmt.Errorf("undefined secret '%s'", parts[1])
-					}
-				default:
-					// Treat everything else as a registered variable
-					if _, ok := s.RegisteredVariables[root]; !ok {
-						return fmt.Errorf("undefined registered variable: '%s'", root)
-					}
-				}
+	// Validate locals, unless validating a component, which shares the locals
+	// of its stack
+	if !s.isComponent() {
+		s.validateLocals(diags, registered)
+	}
+
+	// Validate outputs, which are resolved after all steps ran
+	s.validateOutputs(diags, registered)
+	// Validate that values derived from secrets stay hidden
+	s.validateSecrets(diags)
+
+	// Validate each layer's condition and each step’s condition and params
+	for i := range s.Layers {
+		layer := &s.Layers[i]
+		layerCheck := templateCheck{
+			context:     fmt.Sprintf("invalid condition of layer '%s'", layer.Name),
+			layer:       layer,
+			conditional: true,
+			registered:  registered,
+		}
+		s.checkTemplates(diags, layerCheck, layer.When, "when", layer.positions, layer.Pos)
+		for j := range layer.Steps {
+			step := &layer.Steps[j]
+			// The templates of components are checked with the component
+			if step.use != nil {
+				continue
 			}
-			return nil
-		case map[string]any:
-			for _, val := range v {
-				err := tmplCheck(val)
-				if err != nil {
-					return err
-				}
+			where := fmt.Sprintf("step '%s' in %s", step.Name, layer.describe())
+			check := templateCheck{
+				context:     "invalid template in " + where,
+				where:       where,
+				step:        step,
+				conditional: layer.When != "",
+				registered:  registered,
 			}
-			return nil
-		case []any:
-			for i := range v {
-				err := tmplCheck(v[i])
-				if err != nil {
-					return err
+			s.checkTemplates(diags, check, step.ForEach, "for_each", step.positions, step.Pos)
+			s.checkTemplates(diags, check, step.Count, "count", step.positions, step.Pos)
+			// Every instance of an expanded step has its own $.each
+			check.each = step.ForEach != nil || step.Count != nil
+			check.conditional = true
+			s.checkTemplates(diags, check, step.When, "when", step.positions, step.Pos)
+			check.conditional = layer.When != "" || step.When != ""
+			s.checkTemplates(diags, check, step.Params, "params", step.positions, step.Pos)
+		}
+	}
+}
+
+// Checks the type and templates of every output
+func (s *Stack) validateOutputs(diags *Diagnostics, registered map[string]bool) {
+	for _, name := range sortedKeys(s.Outputs) {
+		output := s.Outputs[name]
+		if output.Value == nil {
+			diags.errorf(output.Pos, CodeOutputMissingValue, "output '%s' has no value", name)
+		}
+		if output.Type != "" {
+			t, err := ParseType(output.Type)
+			if err != nil {
+				diags.errorf(output.positions.at("type", output.Pos), CodeOutputInvalidType, "output '%s' has an %v", name, err)
+			} else if output.Value != nil && !containsTemplate(output.Value) {
+				// Only values without templates are known before resolving
+				if _, err := t.Convert(output.Value); err != nil {
+					diags.errorf(output.positions.at("value", output.Pos), CodeOutputTypeMismatch, "value of output '%s' is not a valid %s: %v", name, t, err)
 				}
 			}
-			return nil
 		}
-		return nil
+		where := fmt.Sprintf("output '%s'", name)
+		check := templateCheck{context: "invalid template in " + where, where: where, registered: registered}
+		s.checkTemplates(diags, check, output.Value, "value", output.positions, output.Pos)
 	}
+}
+
+// Returns whether each registered variable is always registered, because its
+// step doesn't run under a condition
+func (s *Stack) alwaysRegistered() map[string]bool {
+	registered := make(map[string]bool)
+	for _, layer := range s.Layers {
+		for _, step := range layer.Steps {
+			if step.Register != "" {
+				registered[step.Register] = layer.When == "" && step.When == "" && (step.use == nil || step.use.step.When == "")
+			}
+		}
+	}
+	return registered
+}
+
+// Checks that the steps' explicit dependencies exist and that no steps depend
+// on each other. Steps that depend on themselves are reported as self-references.
+func (s *Stack) validateDependencies(diags *Diagnostics) {
+	logrus.WithField("stack", s.Name).Trace("Validating step dependencies")
+	g := s.graph(diags)
+	cycle := g.cycle(true)
+	switch {
+	case cycle == nil || withinComponent(cycle):
+	case len(cycle) == 2:
+		// References to a step's own variable are reported when validating
+		// templates, so this is a step that lists itself in depends_on
+		step := cycle[0].Step
+		pos := step.Pos
+		if i := slices.Index(step.DependsOn, step.Name); i >= 0 {
+			pos = step.positions.at(fmt.Sprintf("depends_on[%d]", i), step.Pos)
+		}
+		diags.errorf(pos, CodeSelfReference, "step '%s' in %s depends on itself", step.Name, cycle[0].Layer.describe())
+	default:
+		diags.errorf(cycle[0].Step.Pos, CodeDependencyCycle, "steps depend on each other: %v", cycleError(cycle))
+	}
+}
 
-	// Validate provider properties
-	err := tmplCheck(s.Provider.Properties)
-	if err != nil {
-		return fmt.Errorf("failed to resolve provider properties: %w", err)
+// Reports whether all steps of a cycle come from the same use of a component,
+// in which case the cycle is reported when validating the component
+func withinComponent(cycle []*Node) bool {
+	for _, n := range cycle {
+		if n.Step.use == nil || n.Step.use != cycle[0].Step.use {
+			return false
+		}
 	}
+	return true
+}
 
-	// Validate each step’s params
+// Returns the step that registers a variable and the layer it is in
+func (s *Stack) registeredBy(name string) (*Layer, *Step) {
 	for i := range s.Layers {
 		for j := range s.Layers[i].Steps {
-			err := tmplCheck(s.Layers[i].Steps[j].Params)
-			if err != nil {
-				return fmt.Errorf("invalid template in step '%s' in layer '%s': %w", s.Layers[i].Steps[j].Name, s.Layers[i].Name, err)
+			if s.Layers[i].Steps[j].Register == name {
+				return &s.Layers[i], &s.Layers[i].Steps[j]
 			}
 		}
 	}
+	return nil, nil
+}
+
+// templateCheck describes where the templates being checked are evaluated
+type templateCheck struct {
+	// Prefixed to the message of every problem found
+	context string
+	// Describes the step the templates belong to in warnings
+	where string
+	// The step or layer the templates belong to, if any
+	step  *Step
+	layer *Layer
+	// The use of a component the templates belong to, if any
+	use *componentUse
+	// Whether the templates are evaluated before any step runs
+	beforeSteps bool
+	// Whether the templates are only evaluated when a condition holds
+	conditional bool
+	// Whether $.each can be referenced
+	each bool
+	// Whether each registered variable is always registered
+	registered map[string]bool
+}
+
+// Checks that every template below val only references known variables, and
+// that steps don't reference their own registered variables. Each problem is
+// reported at the template string it was found in.
+func (s *Stack) checkTemplates(diags *Diagnostics, check templateCheck, val any, path string, pos positions, fallback Position) {
+	switch v := val.(type) {
+	case string:
+		// Check if string contains a template
+		if !strings.Contains(v, "{{") {
+			return
+		}
+		at := pos.at(path, fallback)
+		report := func(code, format string, args ...any) {
+			diags.errorf(at, code, "%s: %s", check.context, fmt.Sprintf(format, args...))
+		}
+		varPaths, err := ExtractVariablePaths(v)
+		if errors.Is(err, ErrUnknownFunction) {
+			report(CodeUnknownFunction, "%v", err)
+			return
+		} else if err != nil {
+			report(CodeTemplateSyntax, "%v", err)
+			return
+		}
 
-	return nil
+		// Validate each variable path
+		for _, parts := range varPaths {
+			if len(parts) == 0 {
+				continue
+			}
+			root := parts[0]
+
+			switch root {
+			case "input":
+				if len(parts) < 2 {
+					report(CodeInvalidReference, "invalid input reference: '%s' (missing key)", strings.Join(parts, "."))
+				} else if _, ok := s.Inputs[parts[1]]; !ok {
+					report(CodeUndefinedInput, "undefined input '%s'", parts[1])
+				}
+			case "secret":
+				if len(parts) < 2 {
+					report(CodeInvalidReference, "invalid secret reference: '%s' (missing key)", strings.Join(parts, "."))
+				} else if _, ok := s.Secrets[parts[1]]; !ok {
+					report(CodeUndefinedSecret, "undefined secret '%s'", parts[1])
+				}
+			case "local":
+				if len(parts) < 2 {
+					report(CodeInvalidReference, "invalid local reference: '%s' (missing key)", strings.Join(parts, "."))
+				} else if _, ok := s.Locals[parts[1]]; !ok {
+					report(CodeUndefinedLocal, "undefined local '%s'", parts[1])
+				}
+			case "each":
+				if !check.each {
+					report(CodeInvalidReference, "'$.each' can only be used in steps with for_each or count")
+				} else if len(parts) < 2 || (parts[1] != "key" && parts[1] != "value") {
+					report(CodeInvalidReference, "invalid each reference: '%s' (expected each.key or each.value)", strings.Join(parts, "."))
+				}
+			default:
+				// Treat everything else as a registered variable
+				name, ok := registeredName(s.RegisteredVariables, parts)
+				if !ok {
+					// Name the variable missing from a namespace, like "network.subnet"
+					if len(parts) > 1 && s.isNamespace(root) {
+						root += "." + parts[1]
+					}
+					report(CodeUndefinedVariable, "undefined registered variable: '%s'", root)
+					continue
+				}
+				root = name
+				if layer, by := s.registeredBy(root); check.beforeSteps {
+					report(CodeForwardReference, "registered variable '%s' is used before step '%s' in %s registers it", root, by.Name, layer.describe())
+				} else if by == check.step {
+					report(CodeSelfReference, "step '%s' references '%s', which it registers itself", by.Name, root)
+				} else if check.use != nil && by.use == check.use {
+					report(CodeSelfReference, "step '%s' references '%s', which its component registers", check.use.step.Name, root)
+				} else if layer == check.layer {
+					report(CodeSelfReference, "layer '%s' references '%s', which its step '%s' registers", layer.Name, root, by.Name)
+				} else if !check.registered[root] && !check.conditional {
+					diags.warnf(at, CodeMaybeAbsent, "%s uses registered variable '%s', which may be absent because step '%s' in %s only runs under a condition", check.where, root, by.Name, layer.describe())
+				}
+			}
+		}
+	case map[string]any:
+		for key, val := range v {
+			s.checkTemplates(diags, check, val, joinPath(path, key), pos, fallback)
+		}
+	case []any:
+		for i := range v {
+			s.checkTemplates(diags, check, v[i], path+"["+strconv.Itoa(i)+"]", pos, fallback)
+		}
+	}
 }
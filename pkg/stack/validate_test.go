@@ -5,6 +5,7 @@ import (
 
 	"github.com/groundctl/groundctl/pkg/stack"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStackValidate(t *testing.T) {
@@ -111,4 +112,472 @@ func TestStackValidate(t *testing.T) {
 		err := s.Validate()
 		assert.ErrorContains(t, err, "undefined input 'env'")
 	})
+
+	t.Run("template errors point at the template string", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: compute
+    steps:
+      - name: Create Instance
+        aws.ec2:
+          tags:
+            - "{{ $.input.env }}"
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		err = s.Validate()
+		assert.EqualError(t, err, "test.yml:12:15: invalid template in step 'Create Instance' in layer 'compute': undefined input 'env'")
+	})
+
+	t.Run("collects every problem", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  region:
+    required: true
+layers:
+  - steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "{{ $.input.env }}"
+          owner: "{{ $.secret.owner }}"
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 4)
+		assert.True(t, diags.HasErrors())
+		assert.Equal(t, stack.CodeInputMissingType, diags[0].Code)
+		assert.Equal(t, stack.Position{File: "test.yml", Line: 7, Column: 3}, diags[0].Pos)
+		assert.Equal(t, stack.CodeLayerMissingName, diags[1].Code)
+		assert.Equal(t, stack.CodeUndefinedInput, diags[2].Code)
+		assert.Equal(t, stack.CodeUndefinedSecret, diags[3].Code)
+		assert.Equal(t, 14, diags[3].Pos.Line)
+	})
+
+	t.Run("input types are checked", func(t *testing.T) {
+		s := &stack.Stack{
+			Version:  "1",
+			Name:     "test",
+			Provider: stack.Provider{Type: "aws"},
+			Inputs: map[string]stack.Input{
+				"name":     {Type: "strng", Default: "web"},
+				"replicas": {Type: "number", Default: "abc"},
+				"zones": {
+					Type:    "list(string)",
+					Default: []any{"a"},
+					Allowed: []stack.AllowedValue{{Value: []any{"a"}}, {Value: "b"}},
+				},
+			},
+		}
+		diags := s.Diagnose()
+		codes := make([]string, 0, len(diags))
+		for _, d := range diags {
+			codes = append(codes, d.Code)
+		}
+		assert.ElementsMatch(t, []string{stack.CodeInputInvalidType, stack.CodeDefaultTypeMismatch, stack.CodeAllowedTypeMismatch}, codes)
+		assert.ErrorContains(t, s.Validate(), "input 'name' has an invalid type 'strng'")
+		assert.ErrorContains(t, s.Validate(), `default value of input 'replicas' is not a valid number: expected number, got string "abc"`)
+	})
+
+	t.Run("input constraints are checked", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  cidr:
+    type: string
+    default: 192.168.0.0/16
+    pattern: '^10\.'
+    messages:
+      pattern: must be a 10.x range
+  replicas:
+    type: integer
+    default: 2
+    min: 3
+    max: 1
+    pattern: '['
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 4)
+		assert.Equal(t, "test.yml:9:14: error: default value of input 'cidr' is invalid: must be a 10.x range [default-constraint]", diags[0].String())
+		assert.Equal(t, stack.CodeInvalidConstraint, diags[1].Code)
+		assert.Equal(t, stack.CodeInvalidConstraint, diags[2].Code)
+		assert.Equal(t, stack.CodeInvalidConstraint, diags[3].Code)
+		assert.ErrorContains(t, s.Validate(), "constraint 'pattern' cannot be used with type integer")
+		assert.ErrorContains(t, s.Validate(), "min 3 is greater than max 1")
+	})
+
+	t.Run("references inside control structures are checked", func(t *testing.T) {
+		s := &stack.Stack{
+			Version: "1",
+			Name:    "test",
+			Provider: stack.Provider{
+				Type: "aws",
+				Properties: map[string]any{
+					"region": `{{ if .input.west }}us-west-2{{ else }}{{ .input.regoin }}{{ end }}`,
+				},
+			},
+			Inputs: map[string]stack.Input{
+				"west":   {Type: "bool", Default: false},
+				"region": {Type: "string", Default: "us-east-1"},
+			},
+		}
+		assert.ErrorContains(t, s.Validate(), "undefined input 'regoin'")
+	})
+
+	t.Run("unknown functions are reported with their position", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    region: "{{ $.input.region | lowercase }}"
+inputs:
+  region:
+    type: string
+    default: us-east-1
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 1)
+		assert.Equal(t, stack.CodeUnknownFunction, diags[0].Code)
+		assert.Equal(t, "test.yml:7:13: error: failed to resolve provider properties: unknown function 'lowercase' [unknown-function]", diags[0].String())
+	})
+
+	t.Run("steps can't depend on themselves or on each other", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    region: "{{ $.my_vpc.region }}"
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "{{ $.my_vpc.name }}"
+        register: my_vpc
+      - name: Create subnet
+        aws.subnet:
+          vpc_id: "{{ $.my_vpc.id }}"
+          route: "{{ $.web_instance.ip }}"
+        register: my_subnet
+  - name: compute
+    steps:
+      - name: Create web instance
+        aws.ec2_instance:
+          subnet_id: "{{ $.my_subnet.id }}"
+        register: web_instance
+      - name: Create DNS record
+        depends_on: [Create web instance, Create database]
+        aws.route53_record:
+          name: web
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 4)
+		assert.Equal(t, "test.yml:7:13: error: failed to resolve provider properties: registered variable 'my_vpc' is used before step 'Create VPC' in layer 'network' registers it [forward-reference]", diags[0].String())
+		assert.Equal(t, "test.yml:13:17: error: invalid template in step 'Create VPC' in layer 'network': step 'Create VPC' references 'my_vpc', which it registers itself [self-reference]", diags[1].String())
+		assert.Equal(t, "test.yml:15:9: error: steps depend on each other: dependency cycle: 'Create subnet' -> 'Create web instance' -> 'Create subnet' [dependency-cycle]", diags[2].String())
+		assert.Equal(t, "test.yml:27:43: error: step 'Create DNS record' in layer 'compute' depends on unknown step 'Create database' [unknown-dependency]", diags[3].String())
+	})
+
+	t.Run("steps can't list themselves in depends_on", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        depends_on: [Create VPC]
+        aws.vpc:
+          name: main
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 1)
+		assert.Equal(t, "test.yml:10:22: error: step 'Create VPC' in layer 'network' depends on itself [self-reference]", diags[0].String())
+
+		g, err := s.Graph()
+		require.NoError(t, err)
+		_, err = g.Order()
+		assert.ErrorIs(t, err, stack.ErrDependencyCycle)
+	})
+
+	t.Run("conditions are checked and conditional variables warned about", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  environment:
+    type: string
+    default: dev
+layers:
+  - name: network
+    steps:
+      - name: Create bastion
+        when: '{{ eq $.input.env "prod" }}'
+        aws.ec2_instance:
+          name: bastion
+        register: bastion
+      - name: Open SSH
+        when: '{{ eq $.input.environment "prod" }}'
+        aws.security_group_rule:
+          target: "{{ $.bastion.id }}"
+  - name: dns
+    when: "{{ $.bastion.id }}"
+    steps:
+      - name: Record
+        aws.route53_record:
+          target: "{{ $.bastion.ip }}"
+  - name: monitoring
+    steps:
+      - name: Alarm
+        aws.alarm:
+          target: "{{ $.bastion.id }}"
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		assert.Equal(t, "aws.ec2_instance", s.Layers[0].Steps[0].Action)
+		diags := s.Diagnose()
+		require.Len(t, diags, 2)
+		assert.Equal(t, "test.yml:14:15: error: invalid template in step 'Create bastion' in layer 'network': undefined input 'env' [undefined-input]", diags[0].String())
+		assert.Equal(t, stack.SeverityWarning, diags[1].Severity)
+		assert.Equal(t, "test.yml:32:19: warning: step 'Alarm' in layer 'monitoring' uses registered variable 'bastion', which may be absent because step 'Create bastion' in layer 'network' only runs under a condition [maybe-absent-variable]", diags[1].String())
+	})
+
+	t.Run("loops and each references are checked", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  zones:
+    type: list(string)
+    default: [us-east-1a]
+layers:
+  - name: network
+    steps:
+      - name: Create Subnet
+        for_each: "{{ $.input.zones }}"
+        aws.subnet:
+          availability_zone: "{{ $.each.value }}"
+          name: "{{ $.each.name }}"
+      - name: Create Instance
+        for_each: [a, b]
+        count: 2
+        aws.ec2_instance:
+          name: web
+      - name: Create Record
+        count: many
+        aws.route53_record:
+          name: "{{ $.each.key }}"
+      - name: Create Zone
+        aws.route53_zone:
+          name: "{{ $.each.key }}"
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 4)
+		assert.Equal(t, "test.yml:17:17: error: invalid template in step 'Create Subnet' in layer 'network': invalid each reference: 'each.name' (expected each.key or each.value) [invalid-reference]", diags[0].String())
+		assert.Equal(t, "test.yml:20:16: error: step 'Create Instance' in layer 'network' can't have both for_each and count [invalid-loop]", diags[1].String())
+		assert.Equal(t, "test.yml:24:16: error: count of step 'Create Record' in layer 'network' must be a whole number or a template [invalid-loop]", diags[2].String())
+		assert.Equal(t, "test.yml:29:17: error: invalid template in step 'Create Zone' in layer 'network': '$.each' can only be used in steps with for_each or count [invalid-reference]", diags[3].String())
+	})
+
+	t.Run("components and their uses are checked", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+components:
+  network_base:
+    inputs:
+      cidr:
+        type: string
+        required: true
+      zones:
+        type: number
+        default: 2
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          cidr_block: "{{ $.input.cidr }}"
+          region: "{{ $.input.region }}"
+        register: vpc
+      - name: Create Subnet
+        aws.subnet:
+          vpc_id: "{{ $.vpc.id }}"
+        depends_on: [Create Route Table]
+layers:
+  - name: network
+    steps:
+      - name: Prod
+        use: network_base
+        with:
+          zones: many
+          size: large
+      - name: Peer
+        aws.vpc_peering:
+          vpc_id: "{{ $.network_base.vpc.id }}"
+          subnet: "{{ $.network_base.subnet }}"
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 6)
+		assert.Equal(t, "test.yml:19:19: error: invalid template in step 'Create VPC' in component 'network_base': undefined input 'region' [undefined-input]", diags[0].String())
+		assert.Equal(t, "test.yml:24:22: error: step 'Create Subnet' in component 'network_base' depends on unknown step 'Create Route Table' [unknown-dependency]", diags[1].String())
+		assert.Equal(t, "test.yml:29:14: error: step 'Prod' in layer 'network' is missing input 'cidr' of component 'network_base' [invalid-component-input]", diags[2].String())
+		assert.Equal(t, "test.yml:31:18: error: step 'Prod' in layer 'network' sets input 'zones' of component 'network_base' to an invalid number: expected number, got string \"many\" [invalid-component-input]", diags[3].String())
+		assert.Equal(t, "test.yml:32:17: error: step 'Prod' in layer 'network' sets unknown input 'size' of component 'network_base' [invalid-component-input]", diags[4].String())
+		assert.Equal(t, "test.yml:36:19: error: invalid template in step 'Peer' in layer 'network': undefined registered variable: 'network_base.subnet' [undefined-variable]", diags[5].String())
+	})
+
+	t.Run("locals are checked for undefined references and cycles", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+inputs:
+  env:
+    type: string
+    default: prod
+locals:
+  prefix: "web-{{ $.input.env }}"
+  name: "{{ $.local.prefix }}-{{ $.local.suffix }}"
+  a: "{{ $.local.b }}"
+  b: "{{ $.local.a }}"
+  vpc_id: "{{ $.vpc.id }}"
+layers:
+  - name: network
+    steps:
+      - name: Create VPC
+        aws.vpc:
+          name: "{{ $.local.name }}"
+          region: "{{ $.local.region }}"
+        register: vpc
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 4)
+		assert.Equal(t, "test.yml:12:9: error: invalid template in local 'name': undefined local 'suffix' [undefined-local]", diags[0].String())
+		assert.Equal(t, "test.yml:13:6: error: locals reference each other: a -> b -> a [local-cycle]", diags[1].String())
+		assert.Equal(t, "test.yml:15:11: error: invalid template in local 'vpc_id': registered variable 'vpc' is used before step 'Create VPC' in layer 'network' registers it [forward-reference]", diags[2].String())
+		assert.Equal(t, "test.yml:22:19: error: invalid template in step 'Create VPC' in layer 'network': undefined local 'region' [undefined-local]", diags[3].String())
+	})
+
+	t.Run("outputs are checked like step params", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+layers:
+  - name: compute
+    steps:
+      - name: Launch Instance
+        aws.ec2:
+          name: web
+        register: web_instance
+outputs:
+  instance_id:
+    value: "{{ $.web_instnace.id }}"
+  endpoints:
+    type: list(string)
+    value:
+      - "{{ $.web_instance.public_ip }}"
+      - "{{ $.input.domain }}"
+  port:
+    type: number
+    value: eighty
+  empty:
+    type: thing
+    sensitive: true
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 5)
+		assert.Equal(t, "test.yml:15:12: error: invalid template in output 'instance_id': undefined registered variable: 'web_instnace' [undefined-variable]", diags[0].String())
+		assert.Equal(t, "test.yml:20:9: error: invalid template in output 'endpoints': undefined input 'domain' [undefined-input]", diags[1].String())
+		assert.Equal(t, "test.yml:23:12: error: value of output 'port' is not a valid number: expected number, got string \"eighty\" [output-type-mismatch]", diags[2].String())
+		assert.Equal(t, "test.yml:24:3: error: output 'empty' has no value [output-missing-value]", diags[3].String())
+		assert.Contains(t, diags[4].String(), "test.yml:25:11: error: output 'empty' has an ")
+	})
+
+	t.Run("secrets can't leak into names, tags or non-sensitive outputs", func(t *testing.T) {
+		yamlData := `
+version: "1.0"
+name: test
+provider:
+  type: aws
+  properties:
+    secret_key: "{{ $.secret.aws_secret_key }}"
+secrets:
+  aws_secret_key:
+    type: string
+  db_password:
+    type: string
+locals:
+  conn: "postgres://admin:{{ $.secret.db_password }}@db"
+  conn_hash: "{{ sha256 $.local.conn }}"
+layers:
+  - name: database
+    steps:
+      - name: "Create {{ $.secret.db_password }}"
+        aws.rds:
+          password: "{{ $.secret.db_password }}"
+        tags: [db, "{{ $.local.conn_hash }}"]
+      - name: Create Users
+        for_each: "{{ split \",\" $.secret.db_password }}"
+        aws.rds_user:
+          name: "{{ $.each.key }}"
+outputs:
+  connection:
+    value: "{{ $.local.conn }}"
+  credentials:
+    value: ["{{ $.secret.aws_secret_key }}", "{{ $.local.conn }}"]
+  hidden:
+    value: "{{ $.local.conn }}"
+    sensitive: true
+`
+		s, err := stack.ParseFile("test.yml", []byte(yamlData))
+		require.NoError(t, err)
+		diags := s.Diagnose()
+		require.Len(t, diags, 5)
+		assert.Equal(t, "test.yml:19:15: error: name of step 'Create {{ $.secret.db_password }}' in layer 'database' is derived from secret 'db_password' [secret-leak]", diags[0].String())
+		assert.Equal(t, "test.yml:22:20: error: tag of step 'Create {{ $.secret.db_password }}' in layer 'database' is derived from secret 'db_password' [secret-leak]", diags[1].String())
+		assert.Equal(t, "test.yml:24:19: error: for_each of step 'Create Users' in layer 'database' is derived from secret 'db_password' [secret-leak]", diags[2].String())
+		assert.Equal(t, "test.yml:29:12: error: output 'connection' is derived from secret 'db_password' but isn't marked sensitive [secret-leak]", diags[3].String())
+		assert.Equal(t, "test.yml:31:5: error: output 'credentials' is derived from secrets 'aws_secret_key' and 'db_password' but isn't marked sensitive [secret-leak]", diags[4].String())
+	})
 }